@@ -0,0 +1,38 @@
+package ksef
+
+import (
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/pkg/here"
+	"github.com/invopop/gobl/tax"
+)
+
+// AddonV2 is the key of the GOBL addon defining the extensions and tags used
+// to generate KSeF FA(2) invoices. Invoices using its tags must include it
+// in their list of addons.
+const AddonV2 cbc.Key = "pl-ksef-fa2"
+
+func init() {
+	tax.RegisterAddonDef(newAddon())
+}
+
+func newAddon() *tax.AddonDef {
+	return &tax.AddonDef{
+		Key: AddonV2,
+		Name: i18n.String{
+			i18n.EN: "Poland KSeF FA(2)",
+			i18n.PL: "KSeF FA(2)",
+		},
+		Description: i18n.String{
+			i18n.EN: here.Doc(`
+				Extensions and tags describing the data of the FA(2) structured invoices
+				of the Polish National e-Invoicing System (KSeF) that is not covered by
+				the GOBL PL regime.
+			`),
+		},
+		Extensions: extensions,
+		Tags: []*tax.TagSet{
+			invoiceTags,
+		},
+	}
+}
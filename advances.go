@@ -0,0 +1,159 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/regimes/pl"
+	"github.com/invopop/gobl/tax"
+)
+
+// Invoice type codes of advance invoices and their settlement
+const (
+	invoiceTypeAdvance    = "ZAL"
+	invoiceTypeSettlement = "ROZ"
+)
+
+// AdvanceInvoice defines the XML structure for KSeF advance invoice
+// references (FakturaZaliczkowa)
+type AdvanceInvoice struct {
+	NoKsefNumberPresent int    `xml:"NrKSeFZN,omitempty"`
+	SequentialNumber    string `xml:"NrFaZaliczkowej,omitempty"`
+	// or
+	KsefNumber string `xml:"NrKSeFFaZaliczkowej,omitempty"`
+}
+
+// Order defines the XML structure for the KSeF order or contract an advance
+// invoice is issued for (Zamowienie)
+type Order struct {
+	Value string       `xml:"WartoscZamowienia"`
+	Lines []*OrderLine `xml:"ZamowienieWiersz"`
+}
+
+// OrderLine defines the XML structure for KSeF order line
+type OrderLine struct {
+	LineNumber    int    `xml:"NrWierszaZam"`
+	Name          string `xml:"P_7Z,omitempty"`
+	Measure       string `xml:"P_8AZ,omitempty"`
+	Quantity      string `xml:"P_8BZ,omitempty"`
+	NetUnitPrice  string `xml:"P_9AZ,omitempty"`
+	NetPriceTotal string `xml:"P_11NettoZ,omitempty"`
+	VATAmount     string `xml:"P_11VatZ,omitempty"`
+	VATRate       string `xml:"P_12Z,omitempty"`
+	OSSTaxRate    string `xml:"P_12Z_XII,omitempty"`
+}
+
+// NewAdvanceInvoice gets the advance invoice reference from a GOBL
+// preceding document
+func NewAdvanceInvoice(prc *org.DocumentRef) *AdvanceInvoice {
+	if id := findStamp(prc.Stamps, string(pl.StampProviderKSeFID)); id != -1 {
+		return &AdvanceInvoice{
+			KsefNumber: prc.Stamps[id].Value,
+		}
+	}
+
+	return &AdvanceInvoice{
+		NoKsefNumberPresent: 1,
+		SequentialNumber:    invoiceNumber(prc.Series, prc.Code),
+	}
+}
+
+// NewOrder gets the order data of an advance invoice from the GOBL invoice,
+// whose lines describe the complete order. Discounts and charges of the
+// whole invoice are added as order lines, as they are part of its value.
+func NewOrder(inv *bill.Invoice) *Order {
+	cu := inv.Currency.Def().Subunits
+	order := &Order{
+		Value: inv.Totals.TotalWithTax.Rescale(cu).String(),
+	}
+	src := append(append([]*bill.Line{}, inv.Lines...), documentLines(inv)...)
+	lines := NewLines(src)
+	setVATCodes(lines, src, newVATContext(inv))
+	for i, l := range lines {
+		ol := &OrderLine{
+			LineNumber:    l.LineNumber,
+			Name:          l.Name,
+			Measure:       l.Measure,
+			Quantity:      l.Quantity,
+			NetUnitPrice:  l.NetUnitPrice,
+			NetPriceTotal: l.NetPriceTotal,
+			VATRate:       l.VATRate,
+			OSSTaxRate:    l.OSSTaxRate,
+		}
+		if pricesIncludeVAT(inv) {
+			// order lines only have net values, so the VAT included in
+			// the line total is taken out of it
+			line := src[i]
+			gross := line.Total.Rescale(cu)
+			vat := num.MakeAmount(0, cu)
+			if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil && tc.Percent != nil {
+				vat = tc.Percent.From(gross).Rescale(cu)
+			}
+			ol.NetUnitPrice = ""
+			ol.NetPriceTotal = gross.Subtract(vat).String()
+			ol.VATAmount = vat.String()
+		}
+		order.Lines = append(order.Lines, ol)
+	}
+	return order
+}
+
+// advanceVATTotals returns the part of the VAT totals of the order covered
+// by the advances received, along with the advance amount. The tax included
+// in the advance is split across VAT groups in proportion to the order.
+func advanceVATTotals(inv *bill.Invoice, vt map[vatGroup]*vatTotal) (map[vatGroup]*vatTotal, num.Amount, error) {
+	t := inv.Totals
+	if t.Advances == nil || t.Advances.IsZero() {
+		return nil, num.AmountZero, fmt.Errorf("missing advance payment for advance invoice")
+	}
+	if t.TotalWithTax.IsZero() {
+		return nil, num.AmountZero, fmt.Errorf("missing order value for advance invoice")
+	}
+
+	cu := inv.Currency.Def().Subunits
+	adv := *t.Advances
+	share := func(a num.Amount) num.Amount {
+		return a.Upscale(4).Multiply(adv).Divide(t.TotalWithTax).Rescale(cu)
+	}
+
+	res := make(map[vatGroup]*vatTotal, len(vt))
+	for g, v := range vt {
+		amount := share(v.Amount)
+		res[g] = &vatTotal{
+			Base:   share(v.Base.Add(v.Amount)).Subtract(amount),
+			Amount: amount,
+		}
+	}
+
+	return res, adv, nil
+}
+
+// settlementVATTotals returns the VAT totals and payable amount of the
+// invoice that remain after deducting the advance invoices being settled,
+// which must include their tax totals and payable amounts.
+func settlementVATTotals(inv *bill.Invoice, vt map[vatGroup]*vatTotal, vc *vatContext) (map[vatGroup]*vatTotal, num.Amount, error) {
+	res := make(map[vatGroup]*vatTotal, len(vt))
+	for g, v := range vt {
+		res[g] = &vatTotal{Base: v.Base, Amount: v.Amount}
+	}
+	payable := inv.Totals.Payable
+
+	for _, prc := range inv.Preceding {
+		if prc.Tax == nil || prc.Payable == nil {
+			return nil, num.AmountZero, fmt.Errorf("missing tax totals of advance invoice %s", invoiceNumber(prc.Series, prc.Code))
+		}
+		for g, v := range newVATTotals(prc.Tax, vc) {
+			if t, ok := res[g]; ok {
+				t.Base = t.Base.Subtract(v.Base)
+				t.Amount = t.Amount.Subtract(v.Amount)
+				continue
+			}
+			res[g] = &vatTotal{Base: v.Base.Negate(), Amount: v.Amount.Negate()}
+		}
+		payable = payable.Subtract(*prc.Payable)
+	}
+
+	return res, payable, nil
+}
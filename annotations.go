@@ -0,0 +1,193 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+)
+
+// splitPaymentThreshold is the invoice total in PLN above which the split
+// payment mechanism is mandatory for annex 15 goods and services
+var splitPaymentThreshold = num.MakeAmount(15000, 0)
+
+// Annotations defines the XML structure for KSeF annotations
+type Annotations struct {
+	CashAccounting                      int           `xml:"P_16"`
+	SelfBilling                         int           `xml:"P_17"`
+	ReverseCharge                       int           `xml:"P_18"`
+	SplitPaymentMechanism               int           `xml:"P_18A"`
+	Exemption                           *Exemption    `xml:"Zwolnienie"`
+	NewTransport                        *NewTransport `xml:"NoweSrodkiTransportu"`
+	SimplifiedProcedureBySecondTaxpayer int           `xml:"P_23"`
+	Margin                              *Margin       `xml:"PMarzy"`
+}
+
+// Exemption defines the XML structure for KSeF VAT exemption annotation
+type Exemption struct {
+	ExemptMarker     int    `xml:"P_19,omitempty"`
+	PolishLegalBasis string `xml:"P_19A,omitempty"`
+	DirectiveBasis   string `xml:"P_19B,omitempty"`
+	OtherLegalBasis  string `xml:"P_19C,omitempty"`
+	NoExemptGoods    int    `xml:"P_19N,omitempty"`
+}
+
+// Margin defines the XML structure for KSeF margin scheme annotation
+type Margin struct {
+	MarginMarker    int `xml:"P_PMarzy,omitempty"`
+	TravelAgency    int `xml:"P_PMarzy_2,omitempty"`
+	SecondHandGoods int `xml:"P_PMarzy_3_1,omitempty"`
+	WorksOfArt      int `xml:"P_PMarzy_3_2,omitempty"`
+	Antiques        int `xml:"P_PMarzy_3_3,omitempty"`
+	NoMarginSchemes int `xml:"P_PMarzyN,omitempty"`
+}
+
+// newAnnotations sets annotations data
+func newAnnotations(inv *bill.Invoice, vt map[vatGroup]*vatTotal, er *currency.ExchangeRate) (*Annotations, error) {
+	// default values for the most common case,
+	// For fields P_16 to P_18 and field P_23 2 means "no", 1 means "yes".
+	// for others 1 means "yes", no value means "no"
+	annotations := &Annotations{
+		CashAccounting:                      2,
+		SelfBilling:                         2,
+		ReverseCharge:                       2,
+		SplitPaymentMechanism:               2,
+		SimplifiedProcedureBySecondTaxpayer: 2,
+	}
+
+	if inv.HasTags(TagCashAccounting) {
+		annotations.CashAccounting = 1
+	}
+
+	if inv.HasTags(tax.TagSelfBilled) {
+		annotations.SelfBilling = 1
+	}
+
+	if inv.HasTags(tax.TagReverseCharge) {
+		annotations.ReverseCharge = 1
+	}
+
+	if inv.HasTags(TagSplitPayment) || requiresSplitPayment(inv, er) {
+		annotations.SplitPaymentMechanism = 1
+	}
+
+	if inv.HasTags(TagTriangulation) {
+		annotations.SimplifiedProcedureBySecondTaxpayer = 1
+	}
+
+	exemption, err := newExemption(inv.Notes, vt[vatGroupExempt] != nil)
+	if err != nil {
+		return nil, err
+	}
+	annotations.Exemption = exemption
+
+	transport, err := newTransport(inv)
+	if err != nil {
+		return nil, err
+	}
+	annotations.NewTransport = transport
+
+	margin, err := newMargin(inv.Totals.Taxes, vt[vatGroupMargin] != nil)
+	if err != nil {
+		return nil, err
+	}
+	annotations.Margin = margin
+
+	return annotations, nil
+}
+
+// newMargin sets the margin scheme annotation from the scheme of the VAT
+// rates of the invoice, which can only use one of them
+func newMargin(taxes *tax.Total, margin bool) (*Margin, error) {
+	if !margin {
+		return &Margin{NoMarginSchemes: 1}, nil
+	}
+
+	var scheme cbc.Code
+	for _, cat := range taxes.Categories {
+		if cat.Code != tax.CategoryVAT {
+			continue
+		}
+		for _, rate := range cat.Rates {
+			s := rate.Ext.Get(ExtKeyMarginScheme)
+			if s == "" {
+				continue
+			}
+			if scheme != "" && s != scheme {
+				return nil, fmt.Errorf("multiple margin schemes in one invoice")
+			}
+			scheme = s
+		}
+	}
+
+	m := &Margin{MarginMarker: 1}
+	switch scheme {
+	case MarginSchemeTravel:
+		m.TravelAgency = 1
+	case MarginSchemeSecondHand:
+		m.SecondHandGoods = 1
+	case MarginSchemeArt:
+		m.WorksOfArt = 1
+	case MarginSchemeAntiques:
+		m.Antiques = 1
+	default:
+		return nil, fmt.Errorf("invalid margin scheme '%s'", scheme)
+	}
+
+	return m, nil
+}
+
+// newExemption sets the exemption annotation. When the invoice contains VAT
+// exempt sales, the legal basis is taken from the legal note carrying the
+// exemption extension.
+func newExemption(notes []*org.Note, exempt bool) (*Exemption, error) {
+	if !exempt {
+		return &Exemption{NoExemptGoods: 1}, nil
+	}
+
+	exemption := &Exemption{ExemptMarker: 1}
+	for _, note := range notes {
+		if note.Key != org.NoteKeyLegal || !note.Ext.Has(ExtKeyExemption) {
+			continue
+		}
+		switch note.Ext[ExtKeyExemption] {
+		case ExemptionAct:
+			exemption.PolishLegalBasis = note.Text
+		case ExemptionDirective:
+			exemption.DirectiveBasis = note.Text
+		case ExemptionOther:
+			exemption.OtherLegalBasis = note.Text
+		default:
+			return nil, fmt.Errorf("invalid exemption legal basis type '%s'", note.Ext[ExtKeyExemption])
+		}
+		return exemption, nil
+	}
+
+	return nil, fmt.Errorf("missing legal note with the basis for VAT exempt sales")
+}
+
+// requiresSplitPayment checks if the invoice total exceeds the split payment
+// threshold and includes annex 15 goods or services
+func requiresSplitPayment(inv *bill.Invoice, er *currency.ExchangeRate) bool {
+	if inv.Totals == nil || !hasAnnex15Items(inv.Lines) {
+		return false
+	}
+	total := inv.Totals.TotalWithTax
+	if er != nil {
+		total = er.Convert(total)
+	}
+	return total.Compare(splitPaymentThreshold) > 0
+}
+
+func hasAnnex15Items(lines []*bill.Line) bool {
+	for _, line := range lines {
+		if line.Item != nil && line.Item.Ext.Get(ExtKeyAnnex15) == "1" {
+			return true
+		}
+	}
+	return false
+}
@@ -89,11 +89,6 @@ func (c *sendOpts) runE(cmd *cobra.Command, args []string) error {
 func SendInvoice(c *ksef_api.Client, data []byte) (*gobl.Envelope, error) {
 	ctx := context.Background()
 
-	err := ksef_api.FetchSessionToken(ctx, c)
-	if err != nil {
-		return nil, err
-	}
-
 	env := new(gobl.Envelope)
 	if err := json.Unmarshal(data, env); err != nil {
 		return nil, fmt.Errorf("parsing input as GOBL Envelope: %w", err)
@@ -104,6 +99,15 @@ func SendInvoice(c *ksef_api.Client, data []byte) (*gobl.Envelope, error) {
 		return nil, fmt.Errorf("building FA_VAT document: %w", err)
 	}
 
+	if err := doc.ValidateContext(c.ID); err != nil {
+		return nil, err
+	}
+
+	err = ksef_api.FetchSessionToken(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err = doc.Bytes()
 	if err != nil {
 		return nil, fmt.Errorf("generating FA_VAT xml: %w", err)
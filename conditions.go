@@ -0,0 +1,341 @@
+package ksef
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/org"
+)
+
+// MetaKeyDeliveryTerms sets the delivery terms of the invoice delivery
+// details, usually an Incoterms rule such as "FCA Warszawa" (WarunkiDostawy)
+const MetaKeyDeliveryTerms cbc.Key = "pl-ksef-delivery-terms"
+
+// Limits of the transport data of FA(2)
+const (
+	maxTransports           = 20 // transports of the transaction conditions
+	maxTransitAddresses     = 20 // transit addresses of a transport
+	maxTransportDescription = 50 // characters of the other transport and cargo descriptions
+)
+
+// transportTypes lists the valid transport type codes of FA(2)
+var transportTypes = []cbc.Code{
+	TransportTypeSea,
+	TransportTypeRail,
+	TransportTypeRoad,
+	TransportTypeAir,
+	TransportTypePost,
+	TransportTypeFixedInstallation,
+	TransportTypeInlandWaterway,
+}
+
+// TransactionConditions defines the XML structure for KSeF transaction
+// conditions (WarunkiTransakcji)
+type TransactionConditions struct {
+	Contracts     []*Contract `xml:"Umowy,omitempty"`
+	Orders        []*Purchase `xml:"Zamowienia,omitempty"`
+	BatchNumbers  []string    `xml:"NrPartiiTowaru,omitempty"`
+	DeliveryTerms string      `xml:"WarunkiDostawy,omitempty"`
+	// contractual exchange rate, used when the amounts were converted
+	// into PLN from the currency of the contract
+	ContractRate       string       `xml:"KursUmowny,omitempty"`
+	ContractCurrency   string       `xml:"WalutaUmowna,omitempty"`
+	Transports         []*Transport `xml:"Transport,omitempty"`
+	IntermediaryMarker int          `xml:"PodmiotPosredniczacy,omitempty"`
+}
+
+// Contract defines the XML structure for KSeF contract reference
+type Contract struct {
+	Date   string `xml:"DataUmowy,omitempty"`
+	Number string `xml:"NrUmowy,omitempty"`
+}
+
+// Purchase defines the XML structure for KSeF purchase order reference
+type Purchase struct {
+	Date   string `xml:"DataZamowienia,omitempty"`
+	Number string `xml:"NrZamowienia,omitempty"`
+}
+
+// Transport defines the XML structure for KSeF transport data
+type Transport struct {
+	TransportType string `xml:"RodzajTransportu,omitempty"`
+	// or
+	OtherTransportMarker      int    `xml:"TransportInny,omitempty"`
+	OtherTransportDescription string `xml:"OpisInnegoTransportu,omitempty"`
+
+	Carrier     *Carrier `xml:"Przewoznik,omitempty"`
+	OrderNumber string   `xml:"NrZleceniaTransportu,omitempty"`
+
+	CargoType string `xml:"OpisLadunku,omitempty"`
+	// or
+	OtherCargoMarker      int    `xml:"LadunekInny,omitempty"`
+	OtherCargoDescription string `xml:"OpisInnegoLadunku,omitempty"`
+
+	PackagingUnit string     `xml:"JednostkaOpakowania,omitempty"`
+	StartTime     string     `xml:"DataGodzRozpTransportu,omitempty"`
+	EndTime       string     `xml:"DataGodzZakTransportu,omitempty"`
+	From          *Address   `xml:"WysylkaZ,omitempty"`
+	Via           []*Address `xml:"WysylkaPrzez,omitempty"`
+	To            *Address   `xml:"WysylkaDo,omitempty"`
+}
+
+// Carrier defines the XML structure for KSeF carrier
+type Carrier struct {
+	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	// or
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	// or
+	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	// or
+	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+
+	Name    string   `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address *Address `xml:"AdresPrzewoznika"`
+}
+
+// NewTransactionConditions gets the transaction conditions from the ordering
+// and delivery details of the GOBL invoice. Each despatch advice of the
+// ordering is reported as a transport, along with the transport parties
+// included as complements of the invoice. Returns nil when there is nothing
+// to report.
+func NewTransactionConditions(inv *bill.Invoice) (*TransactionConditions, error) {
+	tc := &TransactionConditions{
+		BatchNumbers: batchNumbers(inv),
+	}
+
+	if o := inv.Ordering; o != nil {
+		for _, c := range o.Contracts {
+			tc.Contracts = append(tc.Contracts, &Contract{
+				Date:   documentDate(c),
+				Number: invoiceNumber(c.Series, c.Code),
+			})
+		}
+		for _, p := range o.Purchases {
+			tc.Orders = append(tc.Orders, &Purchase{
+				Date:   documentDate(p),
+				Number: invoiceNumber(p.Series, p.Code),
+			})
+		}
+		if len(o.Despatch) > maxTransports {
+			return nil, fmt.Errorf("more than %d despatches for the transports", maxTransports)
+		}
+		for _, d := range o.Despatch {
+			t, err := newDespatchTransport(d, inv.Delivery)
+			if err != nil {
+				return nil, err
+			}
+			tc.Transports = append(tc.Transports, t)
+		}
+	}
+	if err := setTransportParties(tc.Transports, inv); err != nil {
+		return nil, err
+	}
+
+	if d := inv.Delivery; d != nil && d.Meta != nil {
+		tc.DeliveryTerms = (*d.Meta)[MetaKeyDeliveryTerms]
+	}
+
+	if er := contractExchangeRate(inv); er != nil {
+		tc.ContractRate = er.Amount.String()
+		tc.ContractCurrency = string(er.From)
+	}
+
+	if inv.HasTags(TagIntermediary) {
+		tc.IntermediaryMarker = 1
+	}
+
+	if len(tc.Contracts) == 0 && len(tc.Orders) == 0 && len(tc.BatchNumbers) == 0 &&
+		tc.DeliveryTerms == "" && tc.ContractRate == "" && len(tc.Transports) == 0 &&
+		tc.IntermediaryMarker == 0 {
+		return nil, nil
+	}
+
+	return tc, nil
+}
+
+// newDespatchTransport gets the transport data from a despatch advice, whose
+// extensions set the transport and cargo types. Despatches without a
+// transport or cargo type are reported as other transport or cargo
+// described by the document.
+func newDespatchTransport(d *org.DocumentRef, delivery *bill.DeliveryDetails) (*Transport, error) {
+	number := invoiceNumber(d.Series, d.Code)
+	t := &Transport{
+		OrderNumber: number,
+	}
+
+	if tt := d.Ext.Get(ExtKeyTransportType); tt != "" {
+		if !tt.In(transportTypes...) {
+			return nil, fmt.Errorf("invalid transport type '%s' of despatch '%s'", tt, number)
+		}
+		t.TransportType = tt.String()
+	} else {
+		if d.Description == "" {
+			return nil, fmt.Errorf("missing transport type or description of despatch '%s'", number)
+		}
+		t.OtherTransportMarker = 1
+		t.OtherTransportDescription = d.Description
+	}
+
+	if ct := d.Ext.Get(ExtKeyCargoType); ct != "" {
+		if n, err := strconv.Atoi(ct.String()); err != nil || n < 1 || n > 20 {
+			return nil, fmt.Errorf("invalid cargo type '%s' of despatch '%s'", ct, number)
+		}
+		t.CargoType = ct.String()
+	} else {
+		if d.Description == "" {
+			return nil, fmt.Errorf("missing cargo type or description of despatch '%s'", number)
+		}
+		t.OtherCargoMarker = 1
+		t.OtherCargoDescription = d.Description
+	}
+
+	if (t.OtherTransportMarker == 1 || t.OtherCargoMarker == 1) &&
+		utf8.RuneCountInString(d.Description) > maxTransportDescription {
+		return nil, fmt.Errorf("description of despatch '%s' longer than %d characters", number, maxTransportDescription)
+	}
+
+	if d.Period != nil {
+		t.StartTime = startOfDay(d.Period.Start)
+		t.EndTime = endOfDay(d.Period.End)
+	}
+
+	if delivery != nil && delivery.Receiver != nil && len(delivery.Receiver.Addresses) > 0 {
+		t.To = newAddress(delivery.Receiver.Addresses[0])
+	}
+
+	return t, nil
+}
+
+// setTransportParties sets the carrier and the route of the transports from
+// the GOBL parties included as complements of the invoice. The address of
+// the despatcher is the start of the route and the addresses of the transit
+// parties are the stops along it, in order.
+func setTransportParties(transports []*Transport, inv *bill.Invoice) error {
+	var carrier *Carrier
+	var from *Address
+	var via []*Address
+
+	for _, obj := range inv.Complements {
+		party, ok := obj.Instance().(*org.Party)
+		if !ok {
+			continue
+		}
+		tp := party.Ext.Get(ExtKeyTransportParty)
+		if tp == "" {
+			continue
+		}
+		if len(party.Addresses) == 0 {
+			return fmt.Errorf("missing address for transport party '%s'", party.Name)
+		}
+		switch tp {
+		case TransportPartyCarrier:
+			if carrier != nil {
+				return fmt.Errorf("multiple transport carrier parties")
+			}
+			carrier = newCarrier(party)
+		case TransportPartyDespatcher:
+			if from != nil {
+				return fmt.Errorf("multiple transport despatcher parties")
+			}
+			from = newAddress(party.Addresses[0])
+		case TransportPartyTransit:
+			via = append(via, newAddress(party.Addresses[0]))
+		default:
+			return fmt.Errorf("invalid transport party '%s'", tp)
+		}
+	}
+	if len(via) > maxTransitAddresses {
+		return fmt.Errorf("more than %d transit transport parties", maxTransitAddresses)
+	}
+
+	for _, t := range transports {
+		t.Carrier = carrier
+		t.From = from
+		t.Via = via
+	}
+	return nil
+}
+
+func newCarrier(party *org.Party) *Carrier {
+	b := NewBuyer(party)
+	return &Carrier{
+		NIP:         b.NIP,
+		UECode:      b.UECode,
+		UEVatNumber: b.UEVatNumber,
+		CountryCode: b.CountryCode,
+		IDNumber:    b.IDNumber,
+		NoID:        b.NoID,
+		Name:        b.Name,
+		Address:     b.Address,
+	}
+}
+
+// batchNumbers collects the batch numbers of the delivered goods, given as
+// identities of the delivery details or the line items
+func batchNumbers(inv *bill.Invoice) []string {
+	var ids []*org.Identity
+	if inv.Delivery != nil {
+		ids = append(ids, inv.Delivery.Identities...)
+	}
+	for _, line := range inv.Lines {
+		if line.Item != nil {
+			ids = append(ids, line.Item.Identities...)
+		}
+	}
+
+	var numbers []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id.Type != IdentityTypeBatch || seen[id.Code.String()] {
+			continue
+		}
+		seen[id.Code.String()] = true
+		numbers = append(numbers, id.Code.String())
+	}
+	return numbers
+}
+
+// contractExchangeRate finds the rate used to convert the amounts of a PLN
+// invoice from the currency of the contract
+func contractExchangeRate(inv *bill.Invoice) *currency.ExchangeRate {
+	if inv.Currency != currency.PLN {
+		return nil
+	}
+	for _, er := range inv.ExchangeRates {
+		if er.To == currency.PLN && er.From != currency.PLN {
+			return er
+		}
+	}
+	return nil
+}
+
+func documentDate(d *org.DocumentRef) string {
+	if d.IssueDate == nil {
+		return ""
+	}
+	return d.IssueDate.String()
+}
+
+// startOfDay formats a date as the start of the day, as GOBL periods have
+// no time of day
+func startOfDay(d cal.Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.String() + "T00:00:00Z"
+}
+
+// endOfDay formats a date as the end of the day, so the period includes it
+func endOfDay(d cal.Date) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.String() + "T23:59:59Z"
+}
@@ -1,8 +1,48 @@
 package ksef
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/regimes/pl"
+	"github.com/invopop/gobl/tax"
+)
+
+// CorrectedSeller defines the XML structure for the KSeF seller data shown
+// on the corrected invoice (Podmiot1K)
+type CorrectedSeller struct {
+	NIP     string   `xml:"DaneIdentyfikacyjne>NIP"`
+	Name    string   `xml:"DaneIdentyfikacyjne>Nazwa"`
+	Address *Address `xml:"Adres"`
+}
+
+// CorrectedBuyer defines the XML structure for the KSeF buyer data shown on
+// the corrected invoice (Podmiot2K)
+type CorrectedBuyer struct {
+	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	// or
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	// or
+	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	// or
+	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+
+	Name    string   `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address *Address `xml:"Adres,omitempty"`
+	BuyerID string   `xml:"IDNabywcy,omitempty"`
+}
+
+// Invoice type codes of corrections of advance invoices and their
+// settlement, which report the amounts before the correction
+const (
+	invoiceTypeAdvanceCorrection    = "KOR_ZAL"
+	invoiceTypeSettlementCorrection = "KOR_ROZ"
 )
 
 // CorrectedInv defines the XML structure for KSeF correction invoice
@@ -34,6 +74,236 @@ func NewCorrectedInv(prc *org.DocumentRef) *CorrectedInv {
 	return inv
 }
 
+// setCorrectedInvs sets the data of the invoices being corrected from the
+// GOBL preceding documents
+func (f *Inv) setCorrectedInvs(preceding []*org.DocumentRef) error {
+	var reasons []string
+	var payable *num.Amount
+	for _, prc := range preceding {
+		f.CorrectedInvs = append(f.CorrectedInvs, NewCorrectedInv(prc))
+		if prc.Reason != "" && !containsString(reasons, prc.Reason) {
+			reasons = append(reasons, prc.Reason)
+		}
+		if f.CorrectionType == "" && prc.Ext.Has(pl.ExtKeyKSeFEffectiveDate) {
+			f.CorrectionType = prc.Ext[pl.ExtKeyKSeFEffectiveDate].String()
+		}
+		if er := prc.Ext.Get(ExtKeyExchangeRate); er != "" && f.ExchangeRateBeforeCorrection == "" {
+			rate, err := num.AmountFromString(er.String())
+			if err != nil {
+				return fmt.Errorf("invalid exchange rate '%s' of corrected invoice %s", er, invoiceNumber(prc.Series, prc.Code))
+			}
+			f.ExchangeRateBeforeCorrection = rate.RescaleDown(6).String()
+		}
+		if prc.Payable != nil {
+			if payable == nil {
+				payable = prc.Payable
+			} else {
+				sum := payable.Add(*prc.Payable)
+				payable = &sum
+			}
+		}
+	}
+	f.CorrectionReason = strings.Join(reasons, "; ")
+
+	if payable != nil && (f.InvoiceType == invoiceTypeAdvanceCorrection || f.InvoiceType == invoiceTypeSettlementCorrection) {
+		f.TotalAmountBeforeCorrection = payable.String()
+	}
+
+	return nil
+}
+
+// setCorrectedParties sets the seller and buyer data shown on the corrected
+// invoice from the GOBL parties included as complements of the correction.
+// The tax IDs of the seller and buyer are not subject to correction.
+func (f *Inv) setCorrectedParties(inv *bill.Invoice) error {
+	for _, obj := range inv.Complements {
+		party, ok := obj.Instance().(*org.Party)
+		if !ok {
+			continue
+		}
+		switch c := party.Ext.Get(ExtKeyCorrectedParty); c {
+		case "":
+			continue
+		case CorrectedPartySupplier:
+			if f.CorrectedSeller != nil {
+				return fmt.Errorf("multiple corrected supplier parties")
+			}
+			if party.TaxID == nil || len(party.Addresses) == 0 {
+				return fmt.Errorf("missing tax ID or address of corrected supplier party")
+			}
+			s, err := NewSeller(party)
+			if err != nil {
+				return err
+			}
+			f.CorrectedSeller = &CorrectedSeller{
+				NIP:     s.NIP,
+				Name:    s.Name,
+				Address: s.Address,
+			}
+		case CorrectedPartyCustomer:
+			f.CorrectedBuyers = append(f.CorrectedBuyers, newCorrectedBuyer(party))
+		default:
+			return fmt.Errorf("invalid corrected party '%s'", c)
+		}
+	}
+	return nil
+}
+
+func newCorrectedBuyer(party *org.Party) *CorrectedBuyer {
+	b := NewBuyer(party)
+	return &CorrectedBuyer{
+		NIP:         b.NIP,
+		UECode:      b.UECode,
+		UEVatNumber: b.UEVatNumber,
+		CountryCode: b.CountryCode,
+		IDNumber:    b.IDNumber,
+		NoID:        b.NoID,
+		Name:        b.Name,
+		Address:     b.Address,
+	}
+}
+
+// linkCorrectedBuyers sets the same buyer ID in the buyer and the buyer data
+// shown on the corrected invoice, so that they can be matched. The ID is
+// the UUID of the customer without dashes.
+func linkCorrectedBuyers(buyer *Buyer, corrected []*CorrectedBuyer, customer *org.Party) {
+	if len(corrected) == 0 || customer == nil || customer.UUID.IsZero() {
+		return
+	}
+	id := strings.ReplaceAll(customer.UUID.String(), "-", "")
+	buyer.BuyerID = id
+	for _, b := range corrected {
+		b.BuyerID = id
+	}
+}
+
+// correctedPeriod describes the period of the supplies a collective
+// correction refers to, taken from the first corrected document with one
+func correctedPeriod(preceding []*org.DocumentRef) string {
+	for _, prc := range preceding {
+		if prc.Period != nil {
+			return fmt.Sprintf("%s - %s", prc.Period.Start, prc.Period.End)
+		}
+	}
+	return ""
+}
+
+// hasSubstitutedLines checks if any of the lines of a correction describes
+// the state after the correction of other lines
+func hasSubstitutedLines(lines []*bill.Line) bool {
+	for _, line := range lines {
+		if len(line.Substituted) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubstitutedRates ensures the VAT rates of the lines that substitute
+// others were applied by the corrected invoices, when all of them give their
+// tax totals. GOBL substituted lines have no taxes of their own, so the state
+// before the correction is reported with the rate of the corrected line, and
+// a change of rate has to be reported as a line cancelling the previous one
+// and a new line instead.
+func checkSubstitutedRates(inv *bill.Invoice) error {
+	var rates []*tax.RateTotal
+	for _, prc := range inv.Preceding {
+		if prc.Tax == nil {
+			return nil
+		}
+		if ct := prc.Tax.Category(tax.CategoryVAT); ct != nil {
+			rates = append(rates, ct.Rates...)
+		}
+	}
+
+	for _, line := range inv.Lines {
+		combo := line.Taxes.Get(tax.CategoryVAT)
+		if combo == nil || len(line.Substituted) == 0 {
+			continue
+		}
+		if !hasRate(rates, combo) {
+			return fmt.Errorf("VAT rate of corrected line %d not applied by the corrected invoice", line.Index)
+		}
+	}
+
+	return nil
+}
+
+func hasRate(rates []*tax.RateTotal, combo *tax.Combo) bool {
+	for _, rt := range rates {
+		if rt.Key != combo.Rate || rt.Country != combo.Country {
+			continue
+		}
+		if rt.Percent == nil || combo.Percent == nil {
+			if rt.Percent == combo.Percent {
+				return true
+			}
+			continue
+		}
+		if rt.Percent.Equals(*combo.Percent) {
+			return true
+		}
+	}
+	return false
+}
+
+// correctionVATTotals returns the difference between the VAT totals and
+// payable amount of the corrected lines and those of the lines they
+// substitute, which use the VAT rate of the corrected line.
+func correctionVATTotals(inv *bill.Invoice, vt map[vatGroup]*vatTotal, vc *vatContext) (map[vatGroup]*vatTotal, num.Amount) {
+	cu := inv.Currency.Def().Subunits
+	res := make(map[vatGroup]*vatTotal, len(vt))
+	for g, v := range vt {
+		res[g] = &vatTotal{Base: v.Base, Amount: v.Amount}
+	}
+	payable := inv.Totals.Payable
+
+	for _, line := range inv.Lines {
+		combo := line.Taxes.Get(tax.CategoryVAT)
+		if combo == nil {
+			continue
+		}
+		g := newVATGroup(combo.Rate, combo.Country, combo.Ext, vc)
+		for _, sub := range line.Substituted {
+			if sub.Total == nil {
+				continue
+			}
+			base := sub.Total.Rescale(cu)
+			amount := num.MakeAmount(0, cu)
+			if combo.Percent != nil {
+				if pricesIncludeVAT(inv) {
+					// the substituted total is a gross value
+					amount = combo.Percent.From(base).Rescale(cu)
+					base = base.Subtract(amount)
+				} else {
+					amount = combo.Percent.Of(base).Rescale(cu)
+				}
+			}
+			payable = payable.Subtract(base.Add(amount))
+			if g == vatGroupNone {
+				continue
+			}
+			if t, ok := res[g]; ok {
+				t.Base = t.Base.Subtract(base)
+				t.Amount = t.Amount.Subtract(amount)
+				continue
+			}
+			res[g] = &vatTotal{Base: base.Negate(), Amount: amount.Negate()}
+		}
+	}
+
+	return res, payable
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func findStamp(a []*head.Stamp, x string) int {
 	for i, n := range a {
 		if x == string(n.Provider) {
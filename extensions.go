@@ -0,0 +1,947 @@
+package ksef
+
+import (
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/tax"
+)
+
+// Extension keys used to describe KSeF specific data that is not covered by
+// the GOBL PL regime, defined by the KSeF addon.
+const (
+	// ExtKeyExemption identifies the type of legal basis given in a legal
+	// note for VAT exempt sales.
+	ExtKeyExemption cbc.Key = "pl-ksef-exemption"
+	// ExtKeyAnnex15 marks items listed in annex 15 of the VAT act, which are
+	// subject to the split payment mechanism. Expects the value "1".
+	ExtKeyAnnex15 cbc.Key = "pl-ksef-annex-15"
+	// ExtKeyThirdPartyRole sets the role of a party reported as a third
+	// party (Podmiot3), overriding the default role of its position in the
+	// invoice.
+	ExtKeyThirdPartyRole cbc.Key = "pl-ksef-third-party-role"
+	// ExtKeyThirdPartyShare sets the percentage share of an additional
+	// buyer, e.g. "25" or "33.33".
+	ExtKeyThirdPartyShare cbc.Key = "pl-ksef-third-party-share"
+	// ExtKeyAuthorisedRole sets the role of the ordering seller, reported
+	// as the authorised entity (PodmiotUpowazniony) issuing the invoice.
+	ExtKeyAuthorisedRole cbc.Key = "pl-ksef-authorised-role"
+	// ExtKeyExchangeRate sets the PLN exchange rate used by a corrected
+	// invoice issued in a foreign currency, e.g. "4.3215".
+	ExtKeyExchangeRate cbc.Key = "pl-ksef-exchange-rate"
+	// ExtKeyCorrectedParty marks a party included as a complement of a
+	// correction invoice as the data of the supplier or customer shown on
+	// the corrected invoice.
+	ExtKeyCorrectedParty cbc.Key = "pl-ksef-corrected-party"
+	// ExtKeyMarginScheme marks VAT exempt sales taxed under one of the margin
+	// schemes of art. 119 and 120 of the VAT act, where the price includes
+	// the VAT on the margin.
+	ExtKeyMarginScheme cbc.Key = "pl-ksef-margin-scheme"
+	// ExtKeyGTU sets the JPK goods and services group code of an item,
+	// GTU_01 to GTU_13.
+	ExtKeyGTU cbc.Key = "pl-ksef-gtu"
+	// ExtKeyProcedure sets the JPK procedure code of a line, such as
+	// WSTO_EE or TT_D.
+	ExtKeyProcedure cbc.Key = "pl-ksef-procedure"
+	// ExtKeyEarlyPaymentDiscount sets the percentage of the early payment
+	// discount (skonto) of the payment terms, whose conditions are given in
+	// the notes of the terms.
+	ExtKeyEarlyPaymentDiscount cbc.Key = "pl-ksef-early-payment-discount"
+	// ExtKeyBankAccountType marks the only credit transfer account of the
+	// payment instructions as an own account of a bank (RachunekWlasnyBanku).
+	ExtKeyBankAccountType cbc.Key = "pl-ksef-bank-account-type"
+	// ExtKeyTransportType sets the type of transport of a despatch advice
+	// in the invoice ordering (RodzajTransportu).
+	ExtKeyTransportType cbc.Key = "pl-ksef-transport-type"
+	// ExtKeyCargoType sets the type of cargo of a despatch advice with the
+	// codes 1 to 20 of FA(2) (OpisLadunku), e.g. "13" for pallets.
+	ExtKeyCargoType cbc.Key = "pl-ksef-cargo-type"
+	// ExtKeyTransportParty marks a party included as a complement of the
+	// invoice as the carrier, the despatcher or a transit point of the
+	// transports of the invoice.
+	ExtKeyTransportParty cbc.Key = "pl-ksef-transport-party"
+	// ExtKeyTaxpayerStatus sets the status of a supplier in liquidation,
+	// restructuring, bankruptcy or an inherited enterprise
+	// (StatusInfoPodatnika).
+	ExtKeyTaxpayerStatus cbc.Key = "pl-ksef-taxpayer-status"
+)
+
+// ChargeKeyExcise identifies the line charges with the excise duty included
+// in the line amount (KwotaAkcyzy)
+const ChargeKeyExcise cbc.Key = "excise"
+
+// Item identity types reported in KSeF lines
+const (
+	IdentityTypeGTIN  cbc.Code = "GTIN"  // Global Trade Item Number
+	IdentityTypePKWiU cbc.Code = "PKWiU" // Polish Classification of Goods and Services
+	IdentityTypeCN    cbc.Code = "CN"    // Combined Nomenclature
+	IdentityTypePKOB  cbc.Code = "PKOB"  // Polish Classification of Types of Constructions
+)
+
+// Party identity types reported in the seller and buyer data
+const (
+	IdentityTypeEORI           cbc.Code = "EORI"     // Economic Operators Registration and Identification number
+	IdentityTypeCustomerNumber cbc.Code = "CUSTOMER" // Number of the customer given by the supplier
+)
+
+// Supplier identity types reported in the registry data of the invoice footer
+const (
+	IdentityTypeKRS   cbc.Code = "KRS"   // National Court Register number
+	IdentityTypeREGON cbc.Code = "REGON" // Statistical identification number
+	IdentityTypeBDO   cbc.Code = "BDO"   // Waste database registration number
+)
+
+// IdentityTypeBatch identifies the batch numbers of the delivered goods, given
+// in the delivery details or the line items (NrPartiiTowaru)
+const IdentityTypeBatch cbc.Code = "BATCH"
+
+// Legal basis codes for the ExtKeyExemption extension
+const (
+	ExemptionAct       cbc.Code = "act"       // Polish VAT act or regulation (P_19A)
+	ExemptionDirective cbc.Code = "directive" // Directive 2006/112/EC (P_19B)
+	ExemptionOther     cbc.Code = "other"     // Other legal basis (P_19C)
+)
+
+// Role codes for the ExtKeyThirdPartyRole extension, as defined in FA(2)
+const (
+	ThirdPartyRoleFactor           cbc.Code = "1"  // Factor
+	ThirdPartyRoleRecipient        cbc.Code = "2"  // Internal unit or branch of the buyer receiving the goods
+	ThirdPartyRoleOriginal         cbc.Code = "3"  // Acquired or transformed entity that made the supply
+	ThirdPartyRoleBuyer            cbc.Code = "4"  // Additional buyer
+	ThirdPartyRoleIssuer           cbc.Code = "5"  // Issuer of the invoice on behalf of the supplier
+	ThirdPartyRolePayer            cbc.Code = "6"  // Payer on behalf of the buyer
+	ThirdPartyRoleLocalGovIssuer   cbc.Code = "7"  // Local government unit, issuer
+	ThirdPartyRoleLocalGovReceiver cbc.Code = "8"  // Local government unit, recipient
+	ThirdPartyRoleVATGroupIssuer   cbc.Code = "9"  // VAT group member, issuer
+	ThirdPartyRoleVATGroupReceiver cbc.Code = "10" // VAT group member, recipient
+)
+
+// Role codes for the ExtKeyAuthorisedRole extension, as defined in FA(2)
+const (
+	AuthorisedRoleEnforcement       cbc.Code = "1" // Enforcement authority
+	AuthorisedRoleBailiff           cbc.Code = "2" // Court bailiff
+	AuthorisedRoleTaxRepresentative cbc.Code = "3" // Tax representative
+)
+
+// Party codes for the ExtKeyCorrectedParty extension
+const (
+	CorrectedPartySupplier cbc.Code = "supplier" // Corrected seller data (Podmiot1K)
+	CorrectedPartyCustomer cbc.Code = "customer" // Corrected buyer data (Podmiot2K)
+)
+
+// Account type codes for the ExtKeyBankAccountType extension, as defined in
+// FA(2)
+const (
+	BankAccountTypeReceivables cbc.Code = "1" // Account settling the receivables acquired by the bank
+	BankAccountTypeCollection  cbc.Code = "2" // Account collecting the payments due to the supplier
+	BankAccountTypeOwn         cbc.Code = "3" // Own account of the bank, other than a settlement account
+)
+
+// Transport type codes for the ExtKeyTransportType extension, as defined in
+// FA(2)
+const (
+	TransportTypeSea               cbc.Code = "1" // Sea transport
+	TransportTypeRail              cbc.Code = "2" // Rail transport
+	TransportTypeRoad              cbc.Code = "3" // Road transport
+	TransportTypeAir               cbc.Code = "4" // Air transport
+	TransportTypePost              cbc.Code = "5" // Postal consignment
+	TransportTypeFixedInstallation cbc.Code = "7" // Fixed transport installations
+	TransportTypeInlandWaterway    cbc.Code = "8" // Inland waterway transport
+)
+
+// Party codes for the ExtKeyTransportParty extension
+const (
+	TransportPartyCarrier    cbc.Code = "carrier"    // Carrier of the goods (Przewoznik)
+	TransportPartyDespatcher cbc.Code = "despatcher" // Party shipping the goods (WysylkaZ)
+	TransportPartyTransit    cbc.Code = "transit"    // Transit point of the goods (WysylkaPrzez)
+)
+
+// Status codes for the ExtKeyTaxpayerStatus extension, as defined in FA(2)
+const (
+	TaxpayerStatusLiquidation   cbc.Code = "1" // In liquidation
+	TaxpayerStatusRestructuring cbc.Code = "2" // In restructuring proceedings
+	TaxpayerStatusBankruptcy    cbc.Code = "3" // In bankruptcy
+	TaxpayerStatusInheritance   cbc.Code = "4" // Enterprise in inheritance
+)
+
+// Margin scheme codes for the ExtKeyMarginScheme extension
+const (
+	MarginSchemeTravel     cbc.Code = "travel"      // Travel agencies (P_PMarzy_2)
+	MarginSchemeSecondHand cbc.Code = "second-hand" // Second-hand goods (P_PMarzy_3_1)
+	MarginSchemeArt        cbc.Code = "art"         // Works of art (P_PMarzy_3_2)
+	MarginSchemeAntiques   cbc.Code = "antiques"    // Collectors' items and antiques (P_PMarzy_3_3)
+)
+
+// Invoice tags used to set KSeF annotations
+const (
+	// TagCashAccounting marks invoices of taxpayers using the cash
+	// accounting method (metoda kasowa).
+	TagCashAccounting cbc.Key = "cash-accounting"
+	// TagSplitPayment requests the split payment mechanism annotation
+	// even when it is not mandatory.
+	TagSplitPayment cbc.Key = "split-payment"
+	// TagTriangulation marks invoices issued by the second taxpayer in the
+	// simplified intra-community triangular procedure.
+	TagTriangulation cbc.Key = "triangulation"
+	// TagArt42Obligation marks intra-community supplies of new means of
+	// transport subject to the obligation of art. 42 ust. 5 of the VAT act
+	TagArt42Obligation cbc.Key = "art-42-obligation"
+	// TagIntermediary marks supplies made by the intermediary entity of a
+	// chain transaction, art. 22 ust. 2d of the VAT act.
+	TagIntermediary cbc.Key = "intermediary"
+)
+
+// extensions lists the definitions of the extensions registered by the KSeF
+// addon, so GOBL validates their values
+var extensions = []*cbc.Definition{
+	{
+		Key: ExtKeyExemption,
+		Name: i18n.String{
+			i18n.EN: "Exemption Legal Basis",
+			i18n.PL: "Podstawa zwolnienia",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: ExemptionAct,
+				Name: i18n.String{
+					i18n.EN: "Polish VAT act or regulation",
+					i18n.PL: "Ustawa lub akt wydany na jej podstawie",
+				},
+			},
+			{
+				Code: ExemptionDirective,
+				Name: i18n.String{
+					i18n.EN: "Directive 2006/112/EC",
+					i18n.PL: "Dyrektywa 2006/112/WE",
+				},
+			},
+			{
+				Code: ExemptionOther,
+				Name: i18n.String{
+					i18n.EN: "Other legal basis",
+					i18n.PL: "Inna podstawa prawna",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyAnnex15,
+		Name: i18n.String{
+			i18n.EN: "Annex 15 Goods and Services",
+			i18n.PL: "Towary i usługi z załącznika nr 15",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Listed in annex 15 of the VAT act",
+					i18n.PL: "Wymienione w załączniku nr 15 do ustawy",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyThirdPartyRole,
+		Name: i18n.String{
+			i18n.EN: "Third Party Role",
+			i18n.PL: "Rola podmiotu trzeciego",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: ThirdPartyRoleFactor,
+				Name: i18n.String{
+					i18n.EN: "Factor",
+					i18n.PL: "Faktor",
+				},
+			},
+			{
+				Code: ThirdPartyRoleRecipient,
+				Name: i18n.String{
+					i18n.EN: "Recipient",
+					i18n.PL: "Odbiorca",
+				},
+			},
+			{
+				Code: ThirdPartyRoleOriginal,
+				Name: i18n.String{
+					i18n.EN: "Original entity",
+					i18n.PL: "Podmiot pierwotny",
+				},
+			},
+			{
+				Code: ThirdPartyRoleBuyer,
+				Name: i18n.String{
+					i18n.EN: "Additional buyer",
+					i18n.PL: "Dodatkowy nabywca",
+				},
+			},
+			{
+				Code: ThirdPartyRoleIssuer,
+				Name: i18n.String{
+					i18n.EN: "Invoice issuer",
+					i18n.PL: "Wystawca faktury",
+				},
+			},
+			{
+				Code: ThirdPartyRolePayer,
+				Name: i18n.String{
+					i18n.EN: "Payer",
+					i18n.PL: "Dokonujący płatności",
+				},
+			},
+			{
+				Code: ThirdPartyRoleLocalGovIssuer,
+				Name: i18n.String{
+					i18n.EN: "Local government unit, issuer",
+					i18n.PL: "JST - wystawca",
+				},
+			},
+			{
+				Code: ThirdPartyRoleLocalGovReceiver,
+				Name: i18n.String{
+					i18n.EN: "Local government unit, recipient",
+					i18n.PL: "JST - odbiorca",
+				},
+			},
+			{
+				Code: ThirdPartyRoleVATGroupIssuer,
+				Name: i18n.String{
+					i18n.EN: "VAT group member, issuer",
+					i18n.PL: "Członek grupy VAT - wystawca",
+				},
+			},
+			{
+				Code: ThirdPartyRoleVATGroupReceiver,
+				Name: i18n.String{
+					i18n.EN: "VAT group member, recipient",
+					i18n.PL: "Członek grupy VAT - odbiorca",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyThirdPartyShare,
+		Name: i18n.String{
+			i18n.EN: "Third Party Share",
+			i18n.PL: "Udział podmiotu trzeciego",
+		},
+		Pattern: `^\d{1,3}(\.\d{1,6})?$`,
+	},
+	{
+		Key: ExtKeyAuthorisedRole,
+		Name: i18n.String{
+			i18n.EN: "Authorised Entity Role",
+			i18n.PL: "Rola podmiotu upoważnionego",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: AuthorisedRoleEnforcement,
+				Name: i18n.String{
+					i18n.EN: "Enforcement authority",
+					i18n.PL: "Organ egzekucyjny",
+				},
+			},
+			{
+				Code: AuthorisedRoleBailiff,
+				Name: i18n.String{
+					i18n.EN: "Court bailiff",
+					i18n.PL: "Komornik sądowy",
+				},
+			},
+			{
+				Code: AuthorisedRoleTaxRepresentative,
+				Name: i18n.String{
+					i18n.EN: "Tax representative",
+					i18n.PL: "Przedstawiciel podatkowy",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyExchangeRate,
+		Name: i18n.String{
+			i18n.EN: "Exchange Rate of the Corrected Invoice",
+			i18n.PL: "Kurs waluty faktury korygowanej",
+		},
+		Pattern: `^\d+(\.\d+)?$`,
+	},
+	{
+		Key: ExtKeyCorrectedParty,
+		Name: i18n.String{
+			i18n.EN: "Corrected Party",
+			i18n.PL: "Podmiot korygowany",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: CorrectedPartySupplier,
+				Name: i18n.String{
+					i18n.EN: "Seller data of the corrected invoice",
+					i18n.PL: "Dane sprzedawcy z faktury korygowanej",
+				},
+			},
+			{
+				Code: CorrectedPartyCustomer,
+				Name: i18n.String{
+					i18n.EN: "Buyer data of the corrected invoice",
+					i18n.PL: "Dane nabywcy z faktury korygowanej",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyMarginScheme,
+		Name: i18n.String{
+			i18n.EN: "Margin Scheme",
+			i18n.PL: "Procedura marży",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: MarginSchemeTravel,
+				Name: i18n.String{
+					i18n.EN: "Travel agencies",
+					i18n.PL: "Procedura marży dla biur podróży",
+				},
+			},
+			{
+				Code: MarginSchemeSecondHand,
+				Name: i18n.String{
+					i18n.EN: "Second-hand goods",
+					i18n.PL: "Procedura marży - towary używane",
+				},
+			},
+			{
+				Code: MarginSchemeArt,
+				Name: i18n.String{
+					i18n.EN: "Works of art",
+					i18n.PL: "Procedura marży - dzieła sztuki",
+				},
+			},
+			{
+				Code: MarginSchemeAntiques,
+				Name: i18n.String{
+					i18n.EN: "Collectors' items and antiques",
+					i18n.PL: "Procedura marży - przedmioty kolekcjonerskie i antyki",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyGTU,
+		Name: i18n.String{
+			i18n.EN: "Goods and Services Group",
+			i18n.PL: "Grupa towarów i usług (GTU)",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "GTU_01",
+				Name: i18n.String{
+					i18n.EN: "Alcoholic beverages",
+					i18n.PL: "Napoje alkoholowe",
+				},
+			},
+			{
+				Code: "GTU_02",
+				Name: i18n.String{
+					i18n.EN: "Motor fuels",
+					i18n.PL: "Paliwa silnikowe",
+				},
+			},
+			{
+				Code: "GTU_03",
+				Name: i18n.String{
+					i18n.EN: "Heating oil and lubricants",
+					i18n.PL: "Olej opałowy i oleje smarowe",
+				},
+			},
+			{
+				Code: "GTU_04",
+				Name: i18n.String{
+					i18n.EN: "Tobacco products",
+					i18n.PL: "Wyroby tytoniowe",
+				},
+			},
+			{
+				Code: "GTU_05",
+				Name: i18n.String{
+					i18n.EN: "Waste",
+					i18n.PL: "Odpady",
+				},
+			},
+			{
+				Code: "GTU_06",
+				Name: i18n.String{
+					i18n.EN: "Electronic devices",
+					i18n.PL: "Urządzenia elektroniczne",
+				},
+			},
+			{
+				Code: "GTU_07",
+				Name: i18n.String{
+					i18n.EN: "Vehicles and vehicle parts",
+					i18n.PL: "Pojazdy oraz części samochodowe",
+				},
+			},
+			{
+				Code: "GTU_08",
+				Name: i18n.String{
+					i18n.EN: "Precious and base metals",
+					i18n.PL: "Metale szlachetne oraz nieszlachetne",
+				},
+			},
+			{
+				Code: "GTU_09",
+				Name: i18n.String{
+					i18n.EN: "Medicines and medical devices",
+					i18n.PL: "Leki oraz wyroby medyczne",
+				},
+			},
+			{
+				Code: "GTU_10",
+				Name: i18n.String{
+					i18n.EN: "Buildings, structures and land",
+					i18n.PL: "Budynki, budowle i grunty",
+				},
+			},
+			{
+				Code: "GTU_11",
+				Name: i18n.String{
+					i18n.EN: "Greenhouse gas emission allowances",
+					i18n.PL: "Uprawnienia do emisji gazów cieplarnianych",
+				},
+			},
+			{
+				Code: "GTU_12",
+				Name: i18n.String{
+					i18n.EN: "Intangible services",
+					i18n.PL: "Usługi o charakterze niematerialnym",
+				},
+			},
+			{
+				Code: "GTU_13",
+				Name: i18n.String{
+					i18n.EN: "Transport and storage services",
+					i18n.PL: "Usługi transportowe i gospodarki magazynowej",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyProcedure,
+		Name: i18n.String{
+			i18n.EN: "Procedure",
+			i18n.PL: "Procedura",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "WSTO_EE",
+				Name: i18n.String{
+					i18n.EN: "Intra-community distance sales of goods",
+					i18n.PL: "Wewnątrzwspólnotowa sprzedaż towarów na odległość",
+				},
+			},
+			{
+				Code: "IED",
+				Name: i18n.String{
+					i18n.EN: "Supply of goods by an electronic interface",
+					i18n.PL: "Dostawa towarów przez interfejs elektroniczny",
+				},
+			},
+			{
+				Code: "TT_D",
+				Name: i18n.String{
+					i18n.EN: "Supply by the second taxpayer of a triangular transaction",
+					i18n.PL: "Dostawa przez drugiego podatnika w transakcji trójstronnej",
+				},
+			},
+			{
+				Code: "I_42",
+				Name: i18n.String{
+					i18n.EN: "Import under customs procedure 42",
+					i18n.PL: "Import w procedurze celnej 42",
+				},
+			},
+			{
+				Code: "I_63",
+				Name: i18n.String{
+					i18n.EN: "Import under customs procedure 63",
+					i18n.PL: "Import w procedurze celnej 63",
+				},
+			},
+			{
+				Code: "B_SPV",
+				Name: i18n.String{
+					i18n.EN: "Transfer of a single-purpose voucher",
+					i18n.PL: "Transfer bonu jednego przeznaczenia",
+				},
+			},
+			{
+				Code: "B_SPV_DOSTAWA",
+				Name: i18n.String{
+					i18n.EN: "Supply of goods or services of a single-purpose voucher",
+					i18n.PL: "Dostawa towarów lub usług z bonu jednego przeznaczenia",
+				},
+			},
+			{
+				Code: "B_MPV_PROWIZJA",
+				Name: i18n.String{
+					i18n.EN: "Brokerage services of a multi-purpose voucher",
+					i18n.PL: "Usługi pośrednictwa przy transferze bonu różnego przeznaczenia",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyEarlyPaymentDiscount,
+		Name: i18n.String{
+			i18n.EN: "Early Payment Discount",
+			i18n.PL: "Skonto",
+		},
+		Pattern: `^\d{1,3}(\.\d{1,2})?$`,
+	},
+	{
+		Key: ExtKeyBankAccountType,
+		Name: i18n.String{
+			i18n.EN: "Own Bank Account Type",
+			i18n.PL: "Rachunek własny banku",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: BankAccountTypeReceivables,
+				Name: i18n.String{
+					i18n.EN: "Settlement of acquired receivables",
+					i18n.PL: "Rozliczenia nabywanych wierzytelności",
+				},
+			},
+			{
+				Code: BankAccountTypeCollection,
+				Name: i18n.String{
+					i18n.EN: "Collection of payments for the supplier",
+					i18n.PL: "Pobieranie należności na rzecz dostawcy",
+				},
+			},
+			{
+				Code: BankAccountTypeOwn,
+				Name: i18n.String{
+					i18n.EN: "Own account of the bank",
+					i18n.PL: "Rachunek własny banku",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyTransportType,
+		Name: i18n.String{
+			i18n.EN: "Transport Type",
+			i18n.PL: "Rodzaj transportu",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: TransportTypeSea,
+				Name: i18n.String{
+					i18n.EN: "Sea transport",
+					i18n.PL: "Transport morski",
+				},
+			},
+			{
+				Code: TransportTypeRail,
+				Name: i18n.String{
+					i18n.EN: "Rail transport",
+					i18n.PL: "Transport kolejowy",
+				},
+			},
+			{
+				Code: TransportTypeRoad,
+				Name: i18n.String{
+					i18n.EN: "Road transport",
+					i18n.PL: "Transport drogowy",
+				},
+			},
+			{
+				Code: TransportTypeAir,
+				Name: i18n.String{
+					i18n.EN: "Air transport",
+					i18n.PL: "Transport lotniczy",
+				},
+			},
+			{
+				Code: TransportTypePost,
+				Name: i18n.String{
+					i18n.EN: "Postal consignment",
+					i18n.PL: "Przesyłka pocztowa",
+				},
+			},
+			{
+				Code: TransportTypeFixedInstallation,
+				Name: i18n.String{
+					i18n.EN: "Fixed transport installations",
+					i18n.PL: "Stałe instalacje przesyłowe",
+				},
+			},
+			{
+				Code: TransportTypeInlandWaterway,
+				Name: i18n.String{
+					i18n.EN: "Inland waterway transport",
+					i18n.PL: "Żegluga śródlądowa",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyCargoType,
+		Name: i18n.String{
+			i18n.EN: "Cargo Type",
+			i18n.PL: "Opis ładunku",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Can",
+					i18n.PL: "Bańka",
+				},
+			},
+			{
+				Code: "2",
+				Name: i18n.String{
+					i18n.EN: "Barrel",
+					i18n.PL: "Beczka",
+				},
+			},
+			{
+				Code: "3",
+				Name: i18n.String{
+					i18n.EN: "Cylinder",
+					i18n.PL: "Butla",
+				},
+			},
+			{
+				Code: "4",
+				Name: i18n.String{
+					i18n.EN: "Carton",
+					i18n.PL: "Karton",
+				},
+			},
+			{
+				Code: "5",
+				Name: i18n.String{
+					i18n.EN: "Canister",
+					i18n.PL: "Kanister",
+				},
+			},
+			{
+				Code: "6",
+				Name: i18n.String{
+					i18n.EN: "Cage",
+					i18n.PL: "Klatka",
+				},
+			},
+			{
+				Code: "7",
+				Name: i18n.String{
+					i18n.EN: "Container",
+					i18n.PL: "Kontener",
+				},
+			},
+			{
+				Code: "8",
+				Name: i18n.String{
+					i18n.EN: "Basket",
+					i18n.PL: "Kosz/koszyk",
+				},
+			},
+			{
+				Code: "9",
+				Name: i18n.String{
+					i18n.EN: "Punnet",
+					i18n.PL: "Łubianka",
+				},
+			},
+			{
+				Code: "10",
+				Name: i18n.String{
+					i18n.EN: "Bulk package",
+					i18n.PL: "Opakowanie zbiorcze",
+				},
+			},
+			{
+				Code: "11",
+				Name: i18n.String{
+					i18n.EN: "Parcel",
+					i18n.PL: "Paczka",
+				},
+			},
+			{
+				Code: "12",
+				Name: i18n.String{
+					i18n.EN: "Packet",
+					i18n.PL: "Pakiet",
+				},
+			},
+			{
+				Code: "13",
+				Name: i18n.String{
+					i18n.EN: "Pallet",
+					i18n.PL: "Paleta",
+				},
+			},
+			{
+				Code: "14",
+				Name: i18n.String{
+					i18n.EN: "Receptacle",
+					i18n.PL: "Pojemnik",
+				},
+			},
+			{
+				Code: "15",
+				Name: i18n.String{
+					i18n.EN: "Solid bulk receptacle",
+					i18n.PL: "Pojemnik do ładunków masowych stałych",
+				},
+			},
+			{
+				Code: "16",
+				Name: i18n.String{
+					i18n.EN: "Liquid bulk receptacle",
+					i18n.PL: "Pojemnik do ładunków masowych w postaci płynnej",
+				},
+			},
+			{
+				Code: "17",
+				Name: i18n.String{
+					i18n.EN: "Box",
+					i18n.PL: "Pudełko",
+				},
+			},
+			{
+				Code: "18",
+				Name: i18n.String{
+					i18n.EN: "Tin",
+					i18n.PL: "Puszka",
+				},
+			},
+			{
+				Code: "19",
+				Name: i18n.String{
+					i18n.EN: "Crate",
+					i18n.PL: "Skrzynia",
+				},
+			},
+			{
+				Code: "20",
+				Name: i18n.String{
+					i18n.EN: "Bag",
+					i18n.PL: "Worek",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyTransportParty,
+		Name: i18n.String{
+			i18n.EN: "Transport Party",
+			i18n.PL: "Podmiot transportu",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: TransportPartyCarrier,
+				Name: i18n.String{
+					i18n.EN: "Carrier",
+					i18n.PL: "Przewoźnik",
+				},
+			},
+			{
+				Code: TransportPartyDespatcher,
+				Name: i18n.String{
+					i18n.EN: "Despatcher",
+					i18n.PL: "Wysyłka z",
+				},
+			},
+			{
+				Code: TransportPartyTransit,
+				Name: i18n.String{
+					i18n.EN: "Transit point",
+					i18n.PL: "Wysyłka przez",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyTaxpayerStatus,
+		Name: i18n.String{
+			i18n.EN: "Taxpayer Status",
+			i18n.PL: "Status podatnika",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: TaxpayerStatusLiquidation,
+				Name: i18n.String{
+					i18n.EN: "In liquidation",
+					i18n.PL: "Stan likwidacji",
+				},
+			},
+			{
+				Code: TaxpayerStatusRestructuring,
+				Name: i18n.String{
+					i18n.EN: "In restructuring proceedings",
+					i18n.PL: "Postępowanie restrukturyzacyjne",
+				},
+			},
+			{
+				Code: TaxpayerStatusBankruptcy,
+				Name: i18n.String{
+					i18n.EN: "In bankruptcy",
+					i18n.PL: "Stan upadłości",
+				},
+			},
+			{
+				Code: TaxpayerStatusInheritance,
+				Name: i18n.String{
+					i18n.EN: "Enterprise in inheritance",
+					i18n.PL: "Przedsiębiorstwo w spadku",
+				},
+			},
+		},
+	},
+}
+
+// invoiceTags lists the invoice tags of the KSeF addon
+var invoiceTags = &tax.TagSet{
+	Schema: bill.ShortSchemaInvoice,
+	List: []*cbc.Definition{
+		{
+			Key: TagCashAccounting,
+			Name: i18n.String{
+				i18n.EN: "Cash Accounting",
+				i18n.PL: "Metoda kasowa",
+			},
+		},
+		{
+			Key: TagSplitPayment,
+			Name: i18n.String{
+				i18n.EN: "Split Payment Mechanism",
+				i18n.PL: "Mechanizm podzielonej płatności",
+			},
+		},
+		{
+			Key: TagTriangulation,
+			Name: i18n.String{
+				i18n.EN: "Simplified Triangular Procedure",
+				i18n.PL: "Procedura uproszczona wewnątrzwspólnotowej transakcji trójstronnej",
+			},
+		},
+		{
+			Key: TagArt42Obligation,
+			Name: i18n.String{
+				i18n.EN: "New Means of Transport, Art. 42 Obligation",
+				i18n.PL: "Obowiązek z art. 42 ust. 5 ustawy",
+			},
+		},
+		{
+			Key: TagIntermediary,
+			Name: i18n.String{
+				i18n.EN: "Intermediary Entity",
+				i18n.PL: "Podmiot pośredniczący",
+			},
+		},
+	},
+}
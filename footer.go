@@ -0,0 +1,145 @@
+package ksef
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+)
+
+// maxFooterTexts is the number of texts the invoice footer allows
+const maxFooterTexts = 3
+
+// AdditionalDescription defines the XML structure for KSeF additional
+// description key/value pairs (DodatkowyOpis). Descriptions of a single
+// line are given with its line number.
+type AdditionalDescription struct {
+	LineNumber int    `xml:"NrWiersza,omitempty"`
+	Key        string `xml:"Klucz"`
+	Value      string `xml:"Wartosc"`
+}
+
+// Footer defines the XML structure for KSeF invoice footer (Stopka)
+type Footer struct {
+	Information []*FooterInformation `xml:"Informacje,omitempty"`
+	Registries  []*Registry          `xml:"Rejestry,omitempty"`
+}
+
+// FooterInformation defines the XML structure for KSeF footer text
+type FooterInformation struct {
+	Text string `xml:"StopkaFaktury,omitempty"`
+}
+
+// Registry defines the XML structure for KSeF registry data of the seller
+type Registry struct {
+	FullName string `xml:"PelnaNazwa,omitempty"`
+	KRS      string `xml:"KRS,omitempty"`
+	REGON    string `xml:"REGON,omitempty"`
+	BDO      string `xml:"BDO,omitempty"`
+}
+
+// NewAdditionalDescriptions gets the additional descriptions from the notes
+// and meta of the GOBL invoice, followed by the notes of its lines. Legal
+// notes are left for the footer and the exemption annotation.
+func NewAdditionalDescriptions(inv *bill.Invoice) []*AdditionalDescription {
+	var descs []*AdditionalDescription
+
+	for _, note := range inv.Notes {
+		if note.Key == org.NoteKeyLegal {
+			continue
+		}
+		descs = append(descs, newNoteDescription(note, 0))
+	}
+
+	keys := make([]cbc.Key, 0, len(inv.Meta))
+	for k := range inv.Meta {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		descs = append(descs, &AdditionalDescription{
+			Key:   k.String(),
+			Value: inv.Meta[k],
+		})
+	}
+
+	for _, line := range inv.Lines {
+		for _, note := range line.Notes {
+			descs = append(descs, newNoteDescription(note, line.Index))
+		}
+	}
+
+	return descs
+}
+
+// newNoteDescription uses the code of a note as the description key,
+// falling back to its key
+func newNoteDescription(note *org.Note, lineNumber int) *AdditionalDescription {
+	key := note.Code.String()
+	if key == "" {
+		key = note.Key.String()
+	}
+	if key == "" {
+		key = org.NoteKeyGeneral.String()
+	}
+	return &AdditionalDescription{
+		LineNumber: lineNumber,
+		Key:        key,
+		Value:      note.Text,
+	}
+}
+
+// NewFooter gets the invoice footer from the legal notes of the GOBL
+// invoice, other than the legal basis of exemptions, and the registry
+// identities of the supplier. Returns nil when there is nothing to report.
+func NewFooter(inv *bill.Invoice) (*Footer, error) {
+	footer := new(Footer)
+
+	for _, note := range inv.Notes {
+		if note.Key != org.NoteKeyLegal || note.Ext.Has(ExtKeyExemption) {
+			continue
+		}
+		footer.Information = append(footer.Information, &FooterInformation{Text: note.Text})
+	}
+	if len(footer.Information) > maxFooterTexts {
+		return nil, fmt.Errorf("more than %d legal notes for the invoice footer", maxFooterTexts)
+	}
+
+	if r := newRegistry(inv.Supplier); r != nil {
+		footer.Registries = append(footer.Registries, r)
+	}
+
+	if len(footer.Information) == 0 && len(footer.Registries) == 0 {
+		return nil, nil
+	}
+
+	return footer, nil
+}
+
+// newRegistry gets the KRS, REGON and BDO numbers of the supplier from its
+// identities
+func newRegistry(supplier *org.Party) *Registry {
+	if supplier == nil {
+		return nil
+	}
+
+	r := new(Registry)
+	for _, id := range supplier.Identities {
+		switch id.Type {
+		case IdentityTypeKRS:
+			r.KRS = id.Code.String()
+		case IdentityTypeREGON:
+			r.REGON = id.Code.String()
+		case IdentityTypeBDO:
+			r.BDO = id.Code.String()
+		}
+	}
+	if r.KRS == "" && r.REGON == "" && r.BDO == "" {
+		return nil
+	}
+	r.FullName = supplier.Name
+
+	return r
+}
@@ -0,0 +1,74 @@
+package ksef_test
+
+import (
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAdditionalDescriptions(t *testing.T) {
+	t.Run("sets the notes and meta of the invoice and the notes of its lines", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-notes.json")
+		require.NoError(t, err)
+
+		descs := ksef.NewAdditionalDescriptions(inv)
+
+		assert.Equal(t, []*ksef.AdditionalDescription{
+			{Key: "project", Value: "Website redesign"},
+			{Key: "cost-centre", Value: "CC-12"},
+			{Key: "department", Value: "IT"},
+			{LineNumber: 1, Key: "general", Value: "Includes on-site workshops"},
+		}, descs)
+	})
+
+	t.Run("sets no descriptions without notes or meta", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+
+		assert.Empty(t, ksef.NewAdditionalDescriptions(inv))
+	})
+}
+
+func TestNewFooter(t *testing.T) {
+	t.Run("sets the legal notes and registry identities of the supplier", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-notes.json")
+		require.NoError(t, err)
+
+		footer, err := ksef.NewFooter(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.Footer{
+			Information: []*ksef.FooterInformation{
+				{Text: "Provide One Sp. z o.o., Sąd Rejonowy dla m.st. Warszawy, kapitał zakładowy 5 000 PLN"},
+			},
+			Registries: []*ksef.Registry{
+				{FullName: "Provide One S.L.", KRS: "0000123456", REGON: "123456785", BDO: "000012345"},
+			},
+		}, footer)
+	})
+
+	t.Run("does not include the legal basis of exemptions", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-exempt.json")
+		require.NoError(t, err)
+
+		footer, err := ksef.NewFooter(inv)
+		require.NoError(t, err)
+
+		assert.Nil(t, footer)
+	})
+
+	t.Run("fails with more legal notes than the footer allows", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-notes.json")
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			inv.Notes = append(inv.Notes, &org.Note{Key: org.NoteKeyLegal, Text: "Legal note"})
+		}
+
+		_, err = ksef.NewFooter(inv)
+		assert.ErrorContains(t, err, "more than 3 legal notes for the invoice footer")
+	})
+}
@@ -6,136 +6,257 @@ import (
 
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/regimes/pl"
-	"github.com/invopop/gobl/tax"
 )
 
 // Inv defines the XML structure for KSeF invoice
 type Inv struct {
-	CurrencyCode                       string        `xml:"KodWaluty"`
-	IssueDate                          string        `xml:"P_1"`
-	IssuePlace                         string        `xml:"P_1M,omitempty"`
-	SequentialNumber                   string        `xml:"P_2"`
-	CompletionDate                     string        `xml:"P_6,omitempty"`
-	StartDate                          string        `xml:"P_6_Od,omitempty"`
-	EndDate                            string        `xml:"P_6_Do,omitempty"`
-	StandardRateNetSale                string        `xml:"P_13_1,omitempty"`
-	StandardRateTax                    string        `xml:"P_14_1,omitempty"`
-	StandardRateTaxConvertedToPln      string        `xml:"P_14_1W,omitempty"`
-	ReducedRateNetSale                 string        `xml:"P_13_2,omitempty"`
-	ReducedRateTax                     string        `xml:"P_14_2,omitempty"`
-	ReducedRateTaxConvertedToPln       string        `xml:"P_14_2W,omitempty"`
-	SuperReducedRateNetSale            string        `xml:"P_13_3,omitempty"`
-	SuperReducedRateTax                string        `xml:"P_14_3,omitempty"`
-	SuperReducedRateTaxConvertedToPln  string        `xml:"P_14_3W,omitempty"`
-	TaxiRateNetSale                    string        `xml:"P_13_4,omitempty"`
-	TaxiRateTax                        string        `xml:"P_14_4,omitempty"`
-	TaxiRateTaxConvertedToPln          string        `xml:"P_14_4W,omitempty"`
-	SpecialProcedureNetSale            string        `xml:"P_13_5,omitempty"`
-	SpecialProcedureTax                string        `xml:"P_14_5,omitempty"`
-	ZeroTaxExceptIntraCommunityNetSale string        `xml:"P_13_6_1,omitempty"`
-	IntraCommunityNetSale              string        `xml:"P_13_6_2,omitempty"`
-	ExportNetSale                      string        `xml:"P_13_6_3,omitempty"`
-	TaxExemptNetSale                   string        `xml:"P_13_7,omitempty"`
-	InternationalNetSale               string        `xml:"P_13_8,omitempty"`
-	OtherNetSale                       string        `xml:"P_13_9,omitempty"`
-	EUServiceNetSale                   string        `xml:"P_13_10,omitempty"`
-	MarginNetSale                      string        `xml:"P_13_11,omitempty"`
-	TotalAmountReceivable              string        `xml:"P_15"`
-	Annotations                        *Annotations  `xml:"Adnotacje"`
-	InvoiceType                        string        `xml:"RodzajFaktury"`
-	CorrectionReason                   string        `xml:"PrzyczynaKorekty,omitempty"`
-	CorrectionType                     string        `xml:"TypKorekty,omitempty"`
-	CorrectedInv                       *CorrectedInv `xml:"DaneFaKorygowanej,omitempty"`
-	Lines                              []*Line       `xml:"FaWiersz"`
-	Payment                            *Payment      `xml:"Platnosc"`
-}
-
-// Annotations defines the XML structure for KSeF annotations
-type Annotations struct {
-	CashAccounting                      int `xml:"P_16"`
-	SelfBilling                         int `xml:"P_17"`
-	ReverseCharge                       int `xml:"P_18"`
-	SplitPaymentMechanism               int `xml:"P_18A"`
-	NoTaxExemptGoods                    int `xml:"Zwolnienie>P_19N"`
-	NoNewTransportIntraCommunitySupply  int `xml:"NoweSrodkiTransportu>P_22N"`
-	SimplifiedProcedureBySecondTaxpayer int `xml:"P_23"`
-	NoMarginProcedures                  int `xml:"PMarzy>P_PMarzyN"`
-}
-
-// newAnnotations sets annotations data
-func newAnnotations() *Annotations {
-	// default values for the most common case,
-	// For fields P_16 to P_18 and field P_23 2 means "no", 1 means "yes".
-	// for others 1 means "yes", no value means "no"
-	Annotations := &Annotations{
-		CashAccounting:                      2,
-		SelfBilling:                         2,
-		ReverseCharge:                       2,
-		SplitPaymentMechanism:               2,
-		NoTaxExemptGoods:                    1,
-		NoNewTransportIntraCommunitySupply:  1,
-		SimplifiedProcedureBySecondTaxpayer: 2,
-		NoMarginProcedures:                  1,
-	}
-	return Annotations
+	CurrencyCode                       string                   `xml:"KodWaluty"`
+	IssueDate                          string                   `xml:"P_1"`
+	IssuePlace                         string                   `xml:"P_1M,omitempty"`
+	SequentialNumber                   string                   `xml:"P_2"`
+	CompletionDate                     string                   `xml:"P_6,omitempty"`
+	StartDate                          string                   `xml:"P_6_Od,omitempty"`
+	EndDate                            string                   `xml:"P_6_Do,omitempty"`
+	StandardRateNetSale                string                   `xml:"P_13_1,omitempty"`
+	StandardRateTax                    string                   `xml:"P_14_1,omitempty"`
+	StandardRateTaxConvertedToPln      string                   `xml:"P_14_1W,omitempty"`
+	ReducedRateNetSale                 string                   `xml:"P_13_2,omitempty"`
+	ReducedRateTax                     string                   `xml:"P_14_2,omitempty"`
+	ReducedRateTaxConvertedToPln       string                   `xml:"P_14_2W,omitempty"`
+	SuperReducedRateNetSale            string                   `xml:"P_13_3,omitempty"`
+	SuperReducedRateTax                string                   `xml:"P_14_3,omitempty"`
+	SuperReducedRateTaxConvertedToPln  string                   `xml:"P_14_3W,omitempty"`
+	TaxiRateNetSale                    string                   `xml:"P_13_4,omitempty"`
+	TaxiRateTax                        string                   `xml:"P_14_4,omitempty"`
+	TaxiRateTaxConvertedToPln          string                   `xml:"P_14_4W,omitempty"`
+	SpecialProcedureNetSale            string                   `xml:"P_13_5,omitempty"`
+	SpecialProcedureTax                string                   `xml:"P_14_5,omitempty"`
+	ZeroTaxExceptIntraCommunityNetSale string                   `xml:"P_13_6_1,omitempty"`
+	IntraCommunityNetSale              string                   `xml:"P_13_6_2,omitempty"`
+	ExportNetSale                      string                   `xml:"P_13_6_3,omitempty"`
+	TaxExemptNetSale                   string                   `xml:"P_13_7,omitempty"`
+	InternationalNetSale               string                   `xml:"P_13_8,omitempty"`
+	EUServiceNetSale                   string                   `xml:"P_13_9,omitempty"`
+	ReverseChargeNetSale               string                   `xml:"P_13_10,omitempty"`
+	MarginNetSale                      string                   `xml:"P_13_11,omitempty"`
+	TotalAmountReceivable              string                   `xml:"P_15"`
+	AdvanceExchangeRate                string                   `xml:"KursWalutyZ,omitempty"`
+	Annotations                        *Annotations             `xml:"Adnotacje"`
+	InvoiceType                        string                   `xml:"RodzajFaktury"`
+	CorrectionReason                   string                   `xml:"PrzyczynaKorekty,omitempty"`
+	CorrectionType                     string                   `xml:"TypKorekty,omitempty"`
+	CorrectedInvs                      []*CorrectedInv          `xml:"DaneFaKorygowanej,omitempty"`
+	CorrectedPeriod                    string                   `xml:"OkresFaKorygowanej,omitempty"`
+	CorrectedSeller                    *CorrectedSeller         `xml:"Podmiot1K,omitempty"`
+	CorrectedBuyers                    []*CorrectedBuyer        `xml:"Podmiot2K,omitempty"`
+	TotalAmountBeforeCorrection        string                   `xml:"P_15ZK,omitempty"`
+	ExchangeRateBeforeCorrection       string                   `xml:"KursWalutyZK,omitempty"`
+	AdditionalDescriptions             []*AdditionalDescription `xml:"DodatkowyOpis,omitempty"`
+	AdvanceInvoices                    []*AdvanceInvoice        `xml:"FakturaZaliczkowa,omitempty"`
+	Lines                              []*Line                  `xml:"FaWiersz"`
+	Settlement                         *Settlement              `xml:"Rozliczenie,omitempty"`
+	Payment                            *Payment                 `xml:"Platnosc"`
+	TransactionConditions              *TransactionConditions   `xml:"WarunkiTransakcji,omitempty"`
+	Order                              *Order                   `xml:"Zamowienie,omitempty"`
 }
 
 // NewInv gets invoice data from GOBL invoice
-func NewInv(inv *bill.Invoice) *Inv {
+func NewInv(inv *bill.Invoice) (*Inv, error) {
 	cu := inv.Currency.Def().Subunits
+	er, err := plnExchangeRate(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateLines(inv.Lines); err != nil {
+		return nil, err
+	}
+
+	vc := newVATContext(inv)
+	vt := newVATTotals(inv.Totals.Taxes, vc)
+	annotations, err := newAnnotations(inv, vt, er)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := inv.ScenarioSummary() //nolint:staticcheck
+	invoiceType := ss.Codes[pl.KeyFAVATInvoiceType].String()
+
+	payment, err := NewPayment(inv.Payment, inv.Totals)
+	if err != nil {
+		return nil, err
+	}
+
+	// discounts and charges of the whole invoice are reported as lines
+	lines := append(append([]*bill.Line{}, inv.Lines...), documentLines(inv)...)
+
 	Inv := &Inv{
-		Annotations:           newAnnotations(),
-		CurrencyCode:          string(inv.Currency),
-		IssueDate:             inv.IssueDate.String(),
-		SequentialNumber:      invoiceNumber(inv.Series, inv.Code),
-		TotalAmountReceivable: inv.Totals.Payable.Rescale(cu).String(),
-		Lines:                 NewLines(inv.Lines),
-		Payment:               NewPayment(inv.Payment, inv.Totals),
+		Annotations:            annotations,
+		CurrencyCode:           string(inv.Currency),
+		IssueDate:              inv.IssueDate.String(),
+		SequentialNumber:       invoiceNumber(inv.Series, inv.Code),
+		InvoiceType:            invoiceType,
+		Lines:                  NewLines(lines),
+		Payment:                payment,
+		AdditionalDescriptions: NewAdditionalDescriptions(inv),
+	}
+
+	payable := inv.Totals.Payable
+	switch invoiceType {
+	case invoiceTypeAdvance:
+		// the lines describe the order, while the summary only covers
+		// the advance received
+		vt, payable, err = advanceVATTotals(inv, vt)
+		if err != nil {
+			return nil, err
+		}
+		Inv.Order = NewOrder(inv)
+		Inv.Lines = nil
+		if er != nil {
+			// the lines with their exchange rates are not reported
+			Inv.AdvanceExchangeRate = er.Amount.RescaleDown(6).String()
+		}
+		if Inv.Payment != nil {
+			Inv.Payment.setAdvancePaid(inv.Payment.Advances)
+		}
+	case invoiceTypeSettlement:
+		vt, payable, err = settlementVATTotals(inv, vt, vc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if inv.Type == bill.InvoiceTypeCreditNote && hasSubstitutedLines(inv.Lines) {
+		// report the lines before and after the correction, with the
+		// summary showing the difference between them
+		if err := checkSubstitutedRates(inv); err != nil {
+			return nil, err
+		}
+		Inv.Lines = NewCorrectionLines(lines)
+		vt, payable = correctionVATTotals(inv, vt, vc)
+	}
+	if invoiceType != invoiceTypeAdvance {
+		// the payable amount of advance invoices is the advance received
+		Inv.Settlement, payable = newSettlement(inv, payable)
+	}
+	Inv.TotalAmountReceivable = payable.Rescale(cu).String()
+
+	setVATCodes(Inv.Lines, lines, vc)
+	if pricesIncludeVAT(inv) {
+		useGrossPrices(Inv.Lines)
+	}
+
+	if invoiceType == invoiceTypeSimplified {
+		if err := validateSimplified(inv, er); err != nil {
+			return nil, err
+		}
+		simplifyLines(Inv.Lines, lines, inv)
 	}
 
-	if inv.HasTags(tax.TagSelfBilled) {
-		Inv.Annotations.SelfBilling = 1
+	if er != nil {
+		for _, l := range Inv.Lines {
+			l.ExchangeRate = er.Amount.RescaleDown(6).String()
+		}
 	}
 
-	if len(inv.Preceding) > 0 {
+	switch invoiceType {
+	case invoiceTypeAdvance, invoiceTypeSettlement:
+		// preceding documents are the advance invoices being settled
 		for _, prc := range inv.Preceding {
-			Inv.CorrectedInv = NewCorrectedInv(prc)
-			Inv.CorrectionReason = prc.Reason
-			if prc.Ext.Has(pl.ExtKeyKSeFEffectiveDate) {
-				Inv.CorrectionType = prc.Ext[pl.ExtKeyKSeFEffectiveDate].String()
+			Inv.AdvanceInvoices = append(Inv.AdvanceInvoices, NewAdvanceInvoice(prc))
+		}
+	default:
+		if err := Inv.setCorrectedInvs(inv.Preceding); err != nil {
+			return nil, err
+		}
+		if inv.Type == bill.InvoiceTypeCreditNote {
+			Inv.CorrectedPeriod = correctedPeriod(inv.Preceding)
+			if err := Inv.setCorrectedParties(inv); err != nil {
+				return nil, err
 			}
 		}
 	}
 
-	ss := inv.ScenarioSummary() //nolint:staticcheck
-	Inv.InvoiceType = ss.Codes[pl.KeyFAVATInvoiceType].String()
+	Inv.TransactionConditions, err = NewTransactionConditions(inv)
+	if err != nil {
+		return nil, err
+	}
+
 	if inv.OperationDate != nil {
 		Inv.CompletionDate = inv.OperationDate.String()
 	}
-	for _, cat := range inv.Totals.Taxes.Categories {
-		if cat.Code != tax.CategoryVAT {
-			continue
-		}
 
-		for _, rate := range cat.Rates {
-			if rate.Percent != nil {
-				switch rate.Key {
-				case tax.RateStandard:
-					Inv.StandardRateNetSale = rate.Base.Rescale(cu).String()
-					Inv.StandardRateTax = rate.Amount.Rescale(cu).String()
-				case tax.RateReduced:
-					Inv.ReducedRateNetSale = rate.Base.Rescale(cu).String()
-					Inv.ReducedRateTax = rate.Amount.Rescale(cu).String()
-				case tax.RateSuperReduced:
-					Inv.SuperReducedRateNetSale = rate.Base.Rescale(cu).String()
-					Inv.SuperReducedRateTax = rate.Amount.Rescale(cu).String()
-				}
-			}
+	Inv.setVATSummary(vt, cu, er)
+
+	return Inv, nil
+}
+
+// setVATSummary fills the P_13_x and P_14_x fields from the grouped VAT totals
+func (f *Inv) setVATSummary(vt map[vatGroup]*vatTotal, cu uint32, er *currency.ExchangeRate) {
+	for g, t := range vt {
+		base := t.Base.Rescale(cu).String()
+		amount := t.Amount.Rescale(cu).String()
+		switch g {
+		case vatGroupStandard:
+			f.StandardRateNetSale = base
+			f.StandardRateTax = amount
+			f.StandardRateTaxConvertedToPln = amountInPLN(er, t.Amount)
+		case vatGroupReduced:
+			f.ReducedRateNetSale = base
+			f.ReducedRateTax = amount
+			f.ReducedRateTaxConvertedToPln = amountInPLN(er, t.Amount)
+		case vatGroupSuperReduced:
+			f.SuperReducedRateNetSale = base
+			f.SuperReducedRateTax = amount
+			f.SuperReducedRateTaxConvertedToPln = amountInPLN(er, t.Amount)
+		case vatGroupTaxi:
+			f.TaxiRateNetSale = base
+			f.TaxiRateTax = amount
+			f.TaxiRateTaxConvertedToPln = amountInPLN(er, t.Amount)
+		case vatGroupSpecialProcedure:
+			f.SpecialProcedureNetSale = base
+			f.SpecialProcedureTax = amount
+		case vatGroupZeroDomestic:
+			f.ZeroTaxExceptIntraCommunityNetSale = base
+		case vatGroupZeroIntraCommunity:
+			f.IntraCommunityNetSale = base
+		case vatGroupZeroExport:
+			f.ExportNetSale = base
+		case vatGroupExempt:
+			f.TaxExemptNetSale = base
+		case vatGroupNotPursuant:
+			f.InternationalNetSale = base
+		case vatGroupNotPursuantArt100:
+			f.EUServiceNetSale = base
+		case vatGroupReverseCharge:
+			f.ReverseChargeNetSale = base
+		case vatGroupMargin:
+			f.MarginNetSale = base
 		}
 	}
+}
 
-	return Inv
+// plnExchangeRate finds the rate used to convert the invoice currency into
+// PLN. No rate is returned for invoices already issued in PLN.
+func plnExchangeRate(inv *bill.Invoice) (*currency.ExchangeRate, error) {
+	if inv.Currency == currency.PLN {
+		return nil, nil
+	}
+	er := currency.MatchExchangeRate(inv.ExchangeRates, inv.Currency, currency.PLN)
+	if er == nil {
+		return nil, fmt.Errorf("missing exchange rate from %s to %s", inv.Currency, currency.PLN)
+	}
+	return er, nil
+}
+
+// amountInPLN converts the amount using the exchange rate, if any
+func amountInPLN(er *currency.ExchangeRate, amount num.Amount) string {
+	if er == nil {
+		return ""
+	}
+	return er.Convert(amount).String()
 }
 
 func invoiceNumber(series cbc.Code, code cbc.Code) string {
@@ -1,16 +1,25 @@
 package ksef_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/invopop/gobl"
 	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/regimes/pl"
+	"github.com/invopop/gobl/schema"
 	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewInv(t *testing.T) {
@@ -30,9 +39,10 @@ func TestNewInv(t *testing.T) {
 			},
 		}
 
-		invoice := ksef.NewInv(inv)
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
 
-		assert.NotNil(t, invoice.CorrectedInv)
+		assert.Len(t, invoice.CorrectedInvs, 1)
 	})
 
 	t.Run("sets correction reason", func(t *testing.T) {
@@ -55,7 +65,8 @@ func TestNewInv(t *testing.T) {
 			},
 		}
 
-		invoice := ksef.NewInv(inv)
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
 
 		assert.Equal(t, reason, invoice.CorrectionReason)
 	})
@@ -80,11 +91,141 @@ func TestNewInv(t *testing.T) {
 			},
 		}
 
-		invoice := ksef.NewInv(inv)
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
 
 		assert.Equal(t, "1", invoice.CorrectionType)
 	})
 
+	t.Run("sets multiple corrected invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note.json")
+		require.NoError(t, err)
+		inv.Preceding = append(inv.Preceding, &org.DocumentRef{
+			Series: "SAMPLE",
+			Code:   "002",
+			Reason: "Price change",
+			Ext: tax.Extensions{
+				pl.ExtKeyKSeFEffectiveDate: "2",
+			},
+		})
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.Len(t, invoice.CorrectedInvs, 2)
+		assert.Equal(t, "SAMPLE-001", invoice.CorrectedInvs[0].SequentialNumber)
+		assert.Equal(t, "SAMPLE-002", invoice.CorrectedInvs[1].SequentialNumber)
+		assert.Equal(t, 1, invoice.CorrectedInvs[1].NoKsefNumberPresent)
+		assert.Equal(t, "Special Discount; Price change", invoice.CorrectionReason)
+		assert.Equal(t, "2", invoice.CorrectionType)
+	})
+
+	t.Run("reports lines before and after the correction", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note-lines.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.Len(t, invoice.Lines, 2)
+		assert.Equal(t, "1", invoice.Lines[0].BeforeCorrectionMarker)
+		assert.Equal(t, "200.00", invoice.Lines[0].NetPriceTotal)
+		assert.Empty(t, invoice.Lines[1].BeforeCorrectionMarker)
+		assert.Equal(t, "150.00", invoice.Lines[1].NetPriceTotal)
+		assert.Equal(t, "-50.00", invoice.StandardRateNetSale)
+		assert.Equal(t, "-11.50", invoice.StandardRateTax)
+		assert.Equal(t, "-61.50", invoice.TotalAmountReceivable)
+	})
+
+	t.Run("reports the correction of lines with prices including VAT", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note-gross.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "200.00", invoice.Lines[0].GrossPriceTotal)
+		assert.Equal(t, "150.00", invoice.Lines[1].GrossPriceTotal)
+		assert.Equal(t, "-40.65", invoice.StandardRateNetSale)
+		assert.Equal(t, "-9.35", invoice.StandardRateTax)
+		assert.Equal(t, "-50.00", invoice.TotalAmountReceivable)
+	})
+
+	t.Run("accepts corrected lines with the VAT rate of the corrected invoice", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note-lines.json")
+		require.NoError(t, err)
+		inv.Preceding[0].Tax = vatTotal(tax.RateStandard, num.NewPercentage(230, 3))
+
+		_, err = ksef.NewInv(inv)
+		require.NoError(t, err)
+	})
+
+	t.Run("fails when the VAT rate of a corrected line changed", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note-lines.json")
+		require.NoError(t, err)
+		inv.Preceding[0].Tax = vatTotal(tax.RateReduced, num.NewPercentage(80, 3))
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "VAT rate of corrected line 1 not applied by the corrected invoice")
+	})
+
+	t.Run("sets the amount and exchange rate before the correction of advance invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note.json")
+		require.NoError(t, err)
+		inv.SetTags(tax.TagPartial)
+		payable := num.MakeAmount(123000, 2)
+		inv.Preceding[0].Payable = &payable
+		inv.Preceding[0].Ext[ksef.ExtKeyExchangeRate] = "4.3215"
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "KOR_ZAL", invoice.InvoiceType)
+		assert.Equal(t, "1230.00", invoice.TotalAmountBeforeCorrection)
+		assert.Equal(t, "4.3215", invoice.ExchangeRateBeforeCorrection)
+	})
+
+	t.Run("sets the corrected seller and buyer data from complements", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note.json")
+		require.NoError(t, err)
+		supplier := *inv.Supplier
+		supplier.Name = "Provide One Old Name S.L."
+		supplier.Ext = tax.Extensions{ksef.ExtKeyCorrectedParty: ksef.CorrectedPartySupplier}
+		customer := &org.Party{
+			Name: "Old Consumer Name",
+			Ext:  tax.Extensions{ksef.ExtKeyCorrectedParty: ksef.CorrectedPartyCustomer},
+		}
+		for _, p := range []*org.Party{&supplier, customer} {
+			obj, err := schema.NewObject(p)
+			require.NoError(t, err)
+			inv.Complements = append(inv.Complements, obj)
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.NotNil(t, invoice.CorrectedSeller)
+		assert.Equal(t, "Provide One Old Name S.L.", invoice.CorrectedSeller.Name)
+		assert.Equal(t, string(inv.Supplier.TaxID.Code), invoice.CorrectedSeller.NIP)
+		require.Len(t, invoice.CorrectedBuyers, 1)
+		assert.Equal(t, "Old Consumer Name", invoice.CorrectedBuyers[0].Name)
+		assert.Equal(t, 1, invoice.CorrectedBuyers[0].NoID)
+	})
+
+	t.Run("sets the period of collective corrections", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note.json")
+		require.NoError(t, err)
+		inv.Preceding[0].Period = &cal.Period{
+			Start: cal.MakeDate(2023, time.October, 1),
+			End:   cal.MakeDate(2023, time.December, 31),
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "2023-10-01 - 2023-12-31", invoice.CorrectedPeriod)
+	})
+
 	t.Run("sets the self-billing annotation to false in non-self-billed invoices", func(t *testing.T) {
 		inv := &bill.Invoice{
 			Currency: currency.PLN,
@@ -98,7 +239,8 @@ func TestNewInv(t *testing.T) {
 			},
 		}
 
-		invoice := ksef.NewInv(inv)
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
 
 		assert.Equal(t, 2, invoice.Annotations.SelfBilling)
 	})
@@ -116,8 +258,1062 @@ func TestNewInv(t *testing.T) {
 			},
 		}
 
-		invoice := ksef.NewInv(inv)
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
 
 		assert.Equal(t, 1, invoice.Annotations.SelfBilling)
 	})
+
+	t.Run("converts VAT amounts to PLN for foreign currency invoices", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.EUR,
+			ExchangeRates: []*currency.ExchangeRate{
+				{
+					From:   currency.EUR,
+					To:     currency.PLN,
+					Amount: num.MakeAmount(43215, 4),
+				},
+			},
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Lines: []*bill.Line{
+				{
+					Index:    1,
+					Quantity: num.MakeAmount(1, 0),
+					Item: &org.Item{
+						Price: num.NewAmount(10000, 2),
+					},
+					Total: num.NewAmount(10000, 2),
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{
+					Categories: []*tax.CategoryTotal{
+						{
+							Code: tax.CategoryVAT,
+							Rates: []*tax.RateTotal{
+								{
+									Key:     tax.RateStandard,
+									Base:    num.MakeAmount(10000, 2),
+									Percent: num.NewPercentage(230, 3),
+									Amount:  num.MakeAmount(2300, 2),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "EUR", invoice.CurrencyCode)
+		assert.Equal(t, "23.00", invoice.StandardRateTax)
+		assert.Equal(t, "99.39", invoice.StandardRateTaxConvertedToPln)
+		assert.Equal(t, "4.3215", invoice.Lines[0].ExchangeRate)
+	})
+
+	t.Run("fails when a foreign currency invoice has no PLN exchange rate", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.EUR,
+			ExchangeRates: []*currency.ExchangeRate{
+				{
+					From:   currency.EUR,
+					To:     currency.USD,
+					Amount: num.MakeAmount(108, 2),
+				},
+			},
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{},
+			},
+		}
+
+		_, err := ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "missing exchange rate from EUR to PLN")
+	})
+
+	t.Run("maps VAT rates to summary fields", func(t *testing.T) {
+		rate := func(key cbc.Key, base, amount int64, ext tax.Extensions) *tax.RateTotal {
+			return &tax.RateTotal{
+				Key:    key,
+				Ext:    ext,
+				Base:   num.MakeAmount(base, 2),
+				Amount: num.MakeAmount(amount, 2),
+			}
+		}
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Notes: []*org.Note{
+				{
+					Key:  org.NoteKeyLegal,
+					Text: "art. 43 ust. 1 pkt 18 ustawy o VAT",
+					Ext:  tax.Extensions{ksef.ExtKeyExemption: ksef.ExemptionAct},
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{
+					Categories: []*tax.CategoryTotal{
+						{
+							Code: tax.CategoryVAT,
+							Rates: []*tax.RateTotal{
+								rate(tax.RateSpecial, 10000, 400, tax.Extensions{pl.ExtKeyKSeFVATSpecial: "taxi"}),
+								rate(tax.RateZero, 20000, 0, tax.Extensions{pl.ExtKeyKSeFVATZero: "domestic"}),
+								rate(tax.RateZero, 30000, 0, tax.Extensions{pl.ExtKeyKSeFVATZero: "wdt"}),
+								rate(tax.RateZero, 40000, 0, tax.Extensions{pl.ExtKeyKSeFVATZero: "export"}),
+								rate(tax.RateExempt, 50000, 0, nil),
+								rate(tax.RateExempt, 5000, 0, nil),
+								rate(pl.TaxRateNotPursuant, 60000, 0, nil),
+								rate(pl.TaxRateNotPursuantArt100, 70000, 0, nil),
+								rate(tax.RateExempt, 80000, 0, tax.Extensions{ksef.ExtKeyMarginScheme: ksef.MarginSchemeSecondHand}),
+								{
+									Key:     tax.RateStandard,
+									Country: "DE",
+									Percent: num.NewPercentage(190, 3),
+									Base:    num.MakeAmount(10000, 2),
+									Amount:  num.MakeAmount(1900, 2),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "100.00", invoice.TaxiRateNetSale)
+		assert.Equal(t, "4.00", invoice.TaxiRateTax)
+		assert.Equal(t, "100.00", invoice.SpecialProcedureNetSale)
+		assert.Equal(t, "19.00", invoice.SpecialProcedureTax)
+		assert.Equal(t, "200.00", invoice.ZeroTaxExceptIntraCommunityNetSale)
+		assert.Equal(t, "300.00", invoice.IntraCommunityNetSale)
+		assert.Equal(t, "400.00", invoice.ExportNetSale)
+		assert.Equal(t, "550.00", invoice.TaxExemptNetSale)
+		assert.Equal(t, "600.00", invoice.InternationalNetSale)
+		assert.Equal(t, "700.00", invoice.EUServiceNetSale)
+		assert.Equal(t, "800.00", invoice.MarginNetSale)
+		assert.Empty(t, invoice.StandardRateNetSale)
+		assert.Empty(t, invoice.ReverseChargeNetSale)
+	})
+
+	t.Run("maps exempt rates to reverse charge in reverse charge invoices", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Tags:     tax.WithTags(tax.TagReverseCharge),
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{
+					Categories: []*tax.CategoryTotal{
+						{
+							Code: tax.CategoryVAT,
+							Rates: []*tax.RateTotal{
+								{
+									Key:  tax.RateExempt,
+									Base: num.MakeAmount(10000, 2),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "100.00", invoice.ReverseChargeNetSale)
+		assert.Empty(t, invoice.TaxExemptNetSale)
+	})
+
+	t.Run("sets the no exemption annotation when there are no exempt sales", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.Exemption{NoExemptGoods: 1}, invoice.Annotations.Exemption)
+	})
+
+	t.Run("sets the exemption legal basis from legal notes", func(t *testing.T) {
+		tests := []struct {
+			code     cbc.Code
+			expected *ksef.Exemption
+		}{
+			{ksef.ExemptionAct, &ksef.Exemption{ExemptMarker: 1, PolishLegalBasis: "basis"}},
+			{ksef.ExemptionDirective, &ksef.Exemption{ExemptMarker: 1, DirectiveBasis: "basis"}},
+			{ksef.ExemptionOther, &ksef.Exemption{ExemptMarker: 1, OtherLegalBasis: "basis"}},
+		}
+		for _, tt := range tests {
+			inv := exemptInvoice()
+			inv.Notes = []*org.Note{
+				{
+					Key:  org.NoteKeyGeneral,
+					Text: "not a legal note",
+				},
+				{
+					Key:  org.NoteKeyLegal,
+					Text: "basis",
+					Ext:  tax.Extensions{ksef.ExtKeyExemption: tt.code},
+				},
+			}
+
+			invoice, err := ksef.NewInv(inv)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, invoice.Annotations.Exemption)
+		}
+	})
+
+	t.Run("fails when exempt sales have no legal basis", func(t *testing.T) {
+		inv := exemptInvoice()
+
+		_, err := ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "missing legal note with the basis for VAT exempt sales")
+	})
+	t.Run("sets annotations from invoice tags", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Tags: tax.WithTags(
+				ksef.TagCashAccounting,
+				tax.TagReverseCharge,
+				ksef.TagSplitPayment,
+				ksef.TagTriangulation,
+			),
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, invoice.Annotations.CashAccounting)
+		assert.Equal(t, 1, invoice.Annotations.ReverseCharge)
+		assert.Equal(t, 1, invoice.Annotations.SplitPaymentMechanism)
+		assert.Equal(t, 1, invoice.Annotations.SimplifiedProcedureBySecondTaxpayer)
+	})
+
+	t.Run("validates invoices with the tags of the KSeF addon", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+		inv.Addons = tax.WithAddons(ksef.AddonV2)
+		inv.Tags = tax.WithTags(ksef.TagCashAccounting, ksef.TagSplitPayment, ksef.TagTriangulation)
+		inv.Lines[0].Item.Ext = tax.Extensions{ksef.ExtKeyAnnex15: "1"}
+
+		env, err := gobl.Envelop(inv)
+		require.NoError(t, err)
+		require.NoError(t, env.Validate())
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, invoice.Annotations.CashAccounting)
+		assert.Equal(t, 1, invoice.Annotations.SplitPaymentMechanism)
+		assert.Equal(t, 1, invoice.Annotations.SimplifiedProcedureBySecondTaxpayer)
+	})
+
+	t.Run("rejects the tags of the KSeF addon when it is not used", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+		inv.Tags = tax.WithTags(ksef.TagCashAccounting)
+
+		env, err := gobl.Envelop(inv)
+		require.NoError(t, err)
+		assert.ErrorContains(t, env.Validate(), "'cash-accounting' undefined")
+	})
+
+	t.Run("sets split payment for annex 15 goods above the threshold", func(t *testing.T) {
+		inv := annex15Invoice(num.MakeAmount(1500001, 2))
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, invoice.Annotations.SplitPaymentMechanism)
+	})
+
+	t.Run("does not set split payment for annex 15 goods up to the threshold", func(t *testing.T) {
+		inv := annex15Invoice(num.MakeAmount(1500000, 2))
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, invoice.Annotations.SplitPaymentMechanism)
+	})
+
+	t.Run("converts the total to PLN to check the split payment threshold", func(t *testing.T) {
+		inv := annex15Invoice(num.MakeAmount(400000, 2))
+		inv.Currency = currency.EUR
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{
+				From:   currency.EUR,
+				To:     currency.PLN,
+				Amount: num.MakeAmount(43215, 4),
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, invoice.Annotations.SplitPaymentMechanism)
+	})
+	t.Run("reports zero rated supplies to EU customers as intra-community", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{
+					Country: l10n.PL.Tax(),
+				},
+			},
+			Customer: &org.Party{
+				TaxID: &tax.Identity{
+					Country: "DE",
+					Code:    "111111125",
+				},
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{
+					Categories: []*tax.CategoryTotal{
+						{
+							Code: tax.CategoryVAT,
+							Rates: []*tax.RateTotal{
+								{
+									Key:     tax.RateZero,
+									Percent: num.NewPercentage(0, 3),
+									Base:    num.MakeAmount(10000, 2),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "100.00", invoice.IntraCommunityNetSale)
+		assert.Empty(t, invoice.ZeroTaxExceptIntraCommunityNetSale)
+	})
+
+	t.Run("reports the advance received and the order in advance invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-advance.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "ZAL", invoice.InvoiceType)
+		assert.Equal(t, "1112.40", invoice.TotalAmountReceivable)
+		assert.Equal(t, "900.00", invoice.StandardRateNetSale)
+		assert.Equal(t, "207.00", invoice.StandardRateTax)
+		assert.Empty(t, invoice.Lines)
+		require.NotNil(t, invoice.Order)
+		assert.Equal(t, "2224.80", invoice.Order.Value)
+		assert.Len(t, invoice.Order.Lines, 2)
+		assert.Equal(t, "1", invoice.Payment.PaidMarker)
+		assert.Equal(t, "2023-12-18", invoice.Payment.PaymentDate)
+		assert.Empty(t, invoice.Payment.PartiallyPaidMarker)
+		assert.Empty(t, invoice.Payment.AdvancePayments)
+		assert.Empty(t, invoice.AdvanceExchangeRate)
+	})
+
+	t.Run("sets the exchange rate of advance invoices in a foreign currency", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-advance.json")
+		require.NoError(t, err)
+		inv.Currency = currency.EUR
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{
+				From:   currency.EUR,
+				To:     currency.PLN,
+				Amount: num.MakeAmount(43215, 4),
+			},
+		}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Empty(t, invoice.Lines)
+		assert.Equal(t, "4.3215", invoice.AdvanceExchangeRate)
+	})
+
+	t.Run("reports net values of order lines when prices include VAT", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-advance.json")
+		require.NoError(t, err)
+		inv.Tax = &bill.Tax{PricesInclude: tax.CategoryVAT}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.Len(t, invoice.Order.Lines, 2)
+		assert.Empty(t, invoice.Order.Lines[0].NetUnitPrice)
+		assert.Equal(t, "1463.41", invoice.Order.Lines[0].NetPriceTotal)
+		assert.Equal(t, "336.59", invoice.Order.Lines[0].VATAmount)
+		assert.Equal(t, "9.26", invoice.Order.Lines[1].NetPriceTotal)
+		assert.Equal(t, "0.74", invoice.Order.Lines[1].VATAmount)
+	})
+
+	t.Run("reports invoice discounts and charges as order lines", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-discounts.json")
+		require.NoError(t, err)
+
+		order := ksef.NewOrder(inv)
+
+		require.Len(t, order.Lines, 4)
+		assert.Equal(t, "Loyalty discount", order.Lines[2].Name)
+		assert.Equal(t, "-100.00", order.Lines[2].NetPriceTotal)
+		assert.Equal(t, "23", order.Lines[2].VATRate)
+		assert.Equal(t, "Obciążenie", order.Lines[3].Name)
+		assert.Equal(t, "20.00", order.Lines[3].NetPriceTotal)
+		assert.Equal(t, "2126.40", order.Value)
+	})
+
+	t.Run("fails when an advance invoice has no advances", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-advance.json")
+		require.NoError(t, err)
+		inv.Payment = nil
+		inv.Totals.Advances = nil
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "missing advance payment for advance invoice")
+	})
+
+	t.Run("deducts the advance invoices in settlement invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-settlement.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "ROZ", invoice.InvoiceType)
+		assert.Equal(t, "1112.40", invoice.TotalAmountReceivable)
+		assert.Equal(t, "900.00", invoice.StandardRateNetSale)
+		assert.Equal(t, "0.40", invoice.ReducedRateTax)
+		assert.Empty(t, invoice.CorrectedInvs)
+		require.Len(t, invoice.AdvanceInvoices, 1)
+		assert.Equal(t, "1234567788-20231220-8D1E2A4B6C0F-1A", invoice.AdvanceInvoices[0].KsefNumber)
+	})
+
+	t.Run("references advance invoices issued outside KSeF by number", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-settlement.json")
+		require.NoError(t, err)
+		inv.Preceding[0].Stamps = nil
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.Len(t, invoice.AdvanceInvoices, 1)
+		assert.Equal(t, 1, invoice.AdvanceInvoices[0].NoKsefNumberPresent)
+		assert.Equal(t, "ZAL-001", invoice.AdvanceInvoices[0].SequentialNumber)
+		assert.Empty(t, invoice.AdvanceInvoices[0].KsefNumber)
+	})
+
+	t.Run("fails when a settled advance invoice has no totals", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-settlement.json")
+		require.NoError(t, err)
+		inv.Preceding[0].Tax = nil
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "missing tax totals of advance invoice ZAL-001")
+	})
+
+	t.Run("shows gross line values in simplified invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-simplified.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "UPR", invoice.InvoiceType)
+		assert.Equal(t, "332.10", invoice.Lines[0].GrossPriceTotal)
+		assert.Empty(t, invoice.Lines[0].NetPriceTotal)
+		assert.Empty(t, invoice.Lines[0].Quantity)
+	})
+
+	t.Run("does not add VAT to gross line values of simplified invoices when prices include VAT", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-simplified.json")
+		require.NoError(t, err)
+		inv.Tax = &bill.Tax{PricesInclude: tax.CategoryVAT}
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, inv.Lines[0].Total.String(), invoice.Lines[0].GrossPriceTotal)
+		assert.Empty(t, invoice.Lines[0].GrossUnitPrice)
+	})
+
+	t.Run("reports gross prices and values of lines when prices include VAT", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-gross.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "110.70", invoice.Lines[0].GrossUnitPrice)
+		assert.Equal(t, "2214.00", invoice.Lines[0].GrossPriceTotal)
+		assert.Empty(t, invoice.Lines[0].NetUnitPrice)
+		assert.Empty(t, invoice.Lines[0].NetPriceTotal)
+		assert.Equal(t, "1800.00", invoice.StandardRateNetSale)
+		assert.Equal(t, "414.00", invoice.StandardRateTax)
+		assert.Equal(t, "2224.00", invoice.TotalAmountReceivable)
+	})
+
+	t.Run("fails when a simplified invoice exceeds the limit", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-simplified.json")
+		require.NoError(t, err)
+		inv.Totals.TotalWithTax = num.MakeAmount(45001, 2)
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "total of simplified invoice exceeds 450 PLN")
+	})
+
+	t.Run("fails when a simplified invoice in EUR exceeds the limit", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-simplified.json")
+		require.NoError(t, err)
+		inv.Currency = currency.EUR
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{From: currency.EUR, To: currency.PLN, Amount: num.MakeAmount(43215, 4)},
+		}
+		inv.Totals.TotalWithTax = num.MakeAmount(10001, 2)
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "total of simplified invoice exceeds 100 EUR")
+	})
+
+	t.Run("fails when a simplified invoice has no customer tax ID", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-simplified.json")
+		require.NoError(t, err)
+		inv.Customer.TaxID = nil
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "missing customer tax ID for simplified invoice")
+	})
+
+	t.Run("sets the margin scheme annotation and gross line values", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-margin.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.Margin{MarginMarker: 1, SecondHandGoods: 1}, invoice.Annotations.Margin)
+		assert.Equal(t, &ksef.Exemption{NoExemptGoods: 1}, invoice.Annotations.Exemption)
+		assert.Equal(t, "45000.00", invoice.MarginNetSale)
+		assert.Equal(t, "45000.00", invoice.Lines[0].GrossUnitPrice)
+		assert.Equal(t, "45000.00", invoice.Lines[0].GrossPriceTotal)
+		assert.Empty(t, invoice.Lines[0].NetPriceTotal)
+		assert.Empty(t, invoice.Lines[0].VATRate)
+	})
+
+	t.Run("sets the no margin annotation when there are no margin sales", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.Margin{NoMarginSchemes: 1}, invoice.Annotations.Margin)
+	})
+
+	t.Run("fails when the margin scheme is not valid", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-margin.json")
+		require.NoError(t, err)
+		inv.Totals.Taxes.Categories[0].Rates[0].Ext[ksef.ExtKeyMarginScheme] = "other"
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "invalid margin scheme 'other'")
+	})
+
+	t.Run("sets the new means of transport from line item meta", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-new-transport.json")
+		require.NoError(t, err)
+		inv.Tags = tax.WithTags(ksef.TagArt42Obligation)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		nt := invoice.Annotations.NewTransport
+		assert.Equal(t, 1, nt.NewTransportMarker)
+		assert.Equal(t, 1, nt.Art42Obligation)
+		assert.Zero(t, nt.NoNewTransport)
+		require.Len(t, nt.Vehicles, 1)
+		assert.Equal(t, &ksef.NewMeansOfTransport{
+			ApprovalDate:   "2024-02-20",
+			LineNumber:     1,
+			Make:           "Volkswagen",
+			Model:          "Golf",
+			Colour:         "Blue",
+			ProductionYear: "2024",
+			Mileage:        "120",
+			VIN:            "WVWZZZCDZRW000001",
+		}, nt.Vehicles[0])
+	})
+
+	t.Run("fails when a new means of transport is not an intra-community supply", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-new-transport.json")
+		require.NoError(t, err)
+		inv.Customer.TaxID = &tax.Identity{Country: "PL", Code: "1234567788"}
+
+		_, err = ksef.NewInv(inv)
+
+		assert.ErrorContains(t, err, "new means of transport in line 1 not supplied to a customer of another EU member state")
+	})
+
+	t.Run("sets the no new means of transport annotation by default", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.NewTransport{NoNewTransport: 1}, invoice.Annotations.NewTransport)
+	})
+
+	t.Run("fails when a new means of transport is not valid", func(t *testing.T) {
+		tests := []struct {
+			meta cbc.Meta
+			err  string
+		}{
+			{
+				cbc.Meta{ksef.MetaKeyTransportApprovalDate: "20.02.2024", ksef.MetaKeyTransportMileage: "120"},
+				"invalid approval date '20.02.2024' of new means of transport in line 1",
+			},
+			{
+				cbc.Meta{ksef.MetaKeyTransportApprovalDate: "2024-02-20"},
+				"new means of transport in line 1 must have either mileage, sailing hours or flight hours",
+			},
+			{
+				cbc.Meta{ksef.MetaKeyTransportApprovalDate: "2024-02-20", ksef.MetaKeyTransportMileage: "120", ksef.MetaKeyTransportVIN: "1", ksef.MetaKeyTransportFrameNumber: "2"},
+				"new means of transport in line 1 can only have one of VIN, body, chassis or frame number",
+			},
+			{
+				cbc.Meta{ksef.MetaKeyTransportApprovalDate: "2024-02-20", ksef.MetaKeyTransportSailingHours: "10", ksef.MetaKeyTransportVIN: "1"},
+				"new means of transport in line 1 mixes data of different kinds of vehicles",
+			},
+		}
+		for _, tt := range tests {
+			inv, err := test.LoadTestInvoice("invoice-new-transport.json")
+			require.NoError(t, err)
+			inv.Lines[0].Item.Meta = tt.meta
+
+			_, err = ksef.NewInv(inv)
+			assert.ErrorContains(t, err, tt.err)
+		}
+	})
+
+	t.Run("reports the rates of other member states of OSS sales separately", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-oss.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "620.00", invoice.SpecialProcedureNetSale)
+		assert.Empty(t, invoice.Lines[0].VATRate)
+		assert.Equal(t, "19", invoice.Lines[0].OSSTaxRate)
+		assert.Equal(t, "WSTO_EE", invoice.Lines[0].Procedure)
+		assert.Equal(t, "5.5", invoice.Lines[1].OSSTaxRate)
+	})
+
+	t.Run("fails when a line has a VAT rate not valid in Poland", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-oss.json")
+		require.NoError(t, err)
+		inv.Lines[1].Taxes[0].Country = ""
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "invalid VAT rate '5.5' in line 2")
+	})
+
+	t.Run("sets textual VAT rate codes of lines without a percentage", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-exempt.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "zw", invoice.Lines[0].VATRate)
+		assert.Equal(t, "23", invoice.Lines[1].VATRate)
+		assert.Equal(t, "300.00", invoice.TaxExemptNetSale)
+
+		inv.Lines[0].Taxes[0].Rate = pl.TaxRateNotPursuant
+
+		invoice, err = ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "np", invoice.Lines[0].VATRate)
+	})
+
+	t.Run("sets the reverse charge VAT rate code in reverse charge invoices", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-exempt.json")
+		require.NoError(t, err)
+		inv.Tags = tax.WithTags(tax.TagReverseCharge)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "oo", invoice.Lines[0].VATRate)
+		assert.Equal(t, "300.00", invoice.ReverseChargeNetSale)
+	})
+
+	t.Run("reports invoice discounts and charges as lines after the invoice lines", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-discounts.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		require.Len(t, invoice.Lines, 4)
+		assert.Equal(t, 3, invoice.Lines[2].LineNumber)
+		assert.Equal(t, "Loyalty discount", invoice.Lines[2].Name)
+		assert.Equal(t, "-100.00", invoice.Lines[2].NetPriceTotal)
+		assert.Equal(t, "23", invoice.Lines[2].VATRate)
+		assert.Equal(t, 4, invoice.Lines[3].LineNumber)
+		assert.Equal(t, "Obciążenie", invoice.Lines[3].Name)
+		assert.Equal(t, "20.00", invoice.Lines[3].NetPriceTotal)
+		assert.Equal(t, "1720.00", invoice.StandardRateNetSale)
+		assert.Equal(t, "2126.40", invoice.TotalAmountReceivable)
+	})
+
+	t.Run("rejects invalid GTU codes and procedures of lines", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+		inv.Lines[0].Item.Ext = tax.Extensions{ksef.ExtKeyGTU: "GTU_14"}
+
+		env, err := gobl.Envelop(inv)
+		require.NoError(t, err)
+		assert.ErrorContains(t, env.Validate(), "pl-ksef-gtu: value 'GTU_14' invalid")
+
+		inv.Lines[0].Item.Ext = nil
+		inv.Lines[1].Ext = tax.Extensions{ksef.ExtKeyProcedure: "MPP"}
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "invalid extensions in line 2: pl-ksef-procedure: value 'MPP' invalid")
+	})
+
+	t.Run("reports charges and discounts without VAT in the settlement", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-deposits.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Len(t, invoice.Lines, 2)
+		assert.Equal(t, "2224.80", invoice.TotalAmountReceivable)
+		assert.Equal(t, &ksef.Settlement{
+			Charges:         []*ksef.SettlementItem{{Amount: "50.00", Reason: "Returnable pallet deposit"}},
+			TotalCharges:    "50.00",
+			Deductions:      []*ksef.SettlementItem{{Amount: "20.00", Reason: "Overpayment of invoice SAMPLE-001"}},
+			TotalDeductions: "20.00",
+			AmountDue:       "2254.80",
+		}, invoice.Settlement)
+	})
+
+	t.Run("names settlements without a reason in Polish", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-deposits.json")
+		require.NoError(t, err)
+		inv.Charges[0].Reason = ""
+		inv.Discounts[0].Reason = ""
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Obciążenie", invoice.Settlement.Charges[0].Reason)
+		assert.Equal(t, "Rabat", invoice.Settlement.Deductions[0].Reason)
+	})
+
+	t.Run("sets the overpaid amount to settle when deductions exceed the total", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-deposits.json")
+		require.NoError(t, err)
+		inv.Charges = nil
+		inv.Discounts[0].Amount = num.MakeAmount(300000, 2)
+		inv.Totals.Payable = num.MakeAmount(-77520, 2) // 2224.80 - 3000.00
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, "2224.80", invoice.TotalAmountReceivable)
+		assert.Empty(t, invoice.Settlement.AmountDue)
+		assert.Equal(t, "775.20", invoice.Settlement.AmountToSettle)
+	})
+
+	t.Run("sets no settlement when charges and discounts have VAT", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-discounts.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Nil(t, invoice.Settlement)
+	})
+
+	t.Run("sets the transaction conditions from ordering and delivery", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		tc := invoice.TransactionConditions
+		require.NotNil(t, tc)
+		assert.Equal(t, []*ksef.Contract{{Date: "2023-01-10", Number: "UM/2023/15"}}, tc.Contracts)
+		assert.Equal(t, []*ksef.Purchase{{Date: "2023-12-01", Number: "ZAM/452"}}, tc.Orders)
+		assert.Equal(t, []string{"LOT-2023-12"}, tc.BatchNumbers)
+		assert.Equal(t, "FCA Warszawa", tc.DeliveryTerms)
+		assert.Equal(t, "4.4567", tc.ContractRate)
+		assert.Equal(t, "EUR", tc.ContractCurrency)
+		assert.Equal(t, []*ksef.Transport{
+			{
+				TransportType: "3",
+				Carrier: &ksef.Carrier{
+					NIP:  "5213609822",
+					Name: "Trans-Pol Sp. z o.o.",
+					Address: &ksef.Address{
+						CountryCode: "PL",
+						AddressL1:   "Transportowa, 7",
+						AddressL2:   "60-001, Poznań",
+					},
+				},
+				OrderNumber:           "ZT/2023/881",
+				OtherCargoMarker:      1,
+				OtherCargoDescription: "Parts on pallets",
+				StartTime:             "2023-12-15T00:00:00Z",
+				EndTime:               "2023-12-18T23:59:59Z",
+				From: &ksef.Address{
+					CountryCode: "PL",
+					AddressL1:   "Magazynowa, 12",
+					AddressL2:   "90-001, Łódź",
+				},
+			},
+		}, tc.Transports)
+		assert.Zero(t, tc.IntermediaryMarker)
+	})
+
+	t.Run("sets no transaction conditions without ordering or delivery data", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-pl-pl.json")
+		require.NoError(t, err)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		assert.Nil(t, invoice.TransactionConditions)
+	})
+
+	t.Run("sets the cargo type and intermediary marker", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		inv.Ordering.Despatch[0].Ext[ksef.ExtKeyCargoType] = "13"
+		inv.SetTags(ksef.TagIntermediary)
+		require.NoError(t, inv.Validate())
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		tr := invoice.TransactionConditions.Transports[0]
+		assert.Equal(t, "13", tr.CargoType)
+		assert.Zero(t, tr.OtherCargoMarker)
+		assert.Empty(t, tr.OtherCargoDescription)
+		assert.Equal(t, 1, invoice.TransactionConditions.IntermediaryMarker)
+	})
+
+	t.Run("reports despatches without a transport type as other transport", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		delete(inv.Ordering.Despatch[0].Ext, ksef.ExtKeyTransportType)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		tr := invoice.TransactionConditions.Transports[0]
+		assert.Empty(t, tr.TransportType)
+		assert.Equal(t, 1, tr.OtherTransportMarker)
+		assert.Equal(t, "Parts on pallets", tr.OtherTransportDescription)
+
+		inv.Ordering.Despatch[0].Description = ""
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "missing transport type or description of despatch 'ZT/2023/881'")
+	})
+
+	t.Run("sets the transit addresses of the transports", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		transit := &org.Party{
+			Name: "Terminal Kontenerowy",
+			Addresses: []*org.Address{
+				{Street: "Portowa", Number: "3", Locality: "Gdańsk", Code: "80-001", Country: "PL"},
+			},
+			Ext: tax.Extensions{ksef.ExtKeyTransportParty: ksef.TransportPartyTransit},
+		}
+		obj, err := schema.NewObject(transit)
+		require.NoError(t, err)
+		inv.Complements = append(inv.Complements, obj)
+
+		invoice, err := ksef.NewInv(inv)
+		require.NoError(t, err)
+
+		tr := invoice.TransactionConditions.Transports[0]
+		assert.Equal(t, []*ksef.Address{
+			{CountryCode: "PL", AddressL1: "Portowa, 3", AddressL2: "80-001, Gdańsk"},
+		}, tr.Via)
+		assert.Equal(t, "Magazynowa, 12", tr.From.AddressL1)
+	})
+
+	t.Run("fails when a transport party has no address", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		obj, err := schema.NewObject(&org.Party{
+			Name: "Kurier",
+			Ext:  tax.Extensions{ksef.ExtKeyTransportParty: ksef.TransportPartyCarrier},
+		})
+		require.NoError(t, err)
+		inv.Complements = append(inv.Complements, obj)
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "missing address for transport party 'Kurier'")
+	})
+
+	t.Run("fails when the description of other transport or cargo is too long", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		inv.Ordering.Despatch[0].Description = strings.Repeat("x", 51)
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "description of despatch 'ZT/2023/881' longer than 50 characters")
+
+		inv.Ordering.Despatch[0].Ext[ksef.ExtKeyCargoType] = "13"
+
+		_, err = ksef.NewInv(inv)
+		assert.NoError(t, err)
+	})
+
+	t.Run("fails with more than 20 despatches", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		for range 20 {
+			inv.Ordering.Despatch = append(inv.Ordering.Despatch, inv.Ordering.Despatch[0])
+		}
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "more than 20 despatches for the transports")
+	})
+
+	t.Run("fails when a despatch has an invalid transport or cargo type", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("invoice-conditions.json")
+		require.NoError(t, err)
+		inv.Ordering.Despatch[0].Ext[ksef.ExtKeyTransportType] = "6"
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "invalid transport type '6' of despatch 'ZT/2023/881'")
+
+		inv.Ordering.Despatch[0].Ext[ksef.ExtKeyTransportType] = "3"
+		inv.Ordering.Despatch[0].Ext[ksef.ExtKeyCargoType] = "21"
+
+		_, err = ksef.NewInv(inv)
+		assert.ErrorContains(t, err, "invalid cargo type '21' of despatch 'ZT/2023/881'")
+	})
+}
+
+func annex15Invoice(total num.Amount) *bill.Invoice {
+	return &bill.Invoice{
+		Currency: currency.PLN,
+		Supplier: &org.Party{
+			TaxID: &tax.Identity{
+				Country: l10n.PL.Tax(),
+			},
+		},
+		Lines: []*bill.Line{
+			{
+				Index:    1,
+				Quantity: num.MakeAmount(1, 0),
+				Item: &org.Item{
+					Name:  "Laptop",
+					Price: &total,
+					Ext:   tax.Extensions{ksef.ExtKeyAnnex15: "1"},
+				},
+				Total: &total,
+			},
+		},
+		Totals: &bill.Totals{
+			TotalWithTax: total,
+			Taxes:        &tax.Total{},
+		},
+	}
+}
+
+func exemptInvoice() *bill.Invoice {
+	return &bill.Invoice{
+		Currency: currency.PLN,
+		Supplier: &org.Party{
+			TaxID: &tax.Identity{
+				Country: l10n.PL.Tax(),
+			},
+		},
+		Totals: &bill.Totals{
+			Taxes: &tax.Total{
+				Categories: []*tax.CategoryTotal{
+					{
+						Code: tax.CategoryVAT,
+						Rates: []*tax.RateTotal{
+							{
+								Key:  tax.RateExempt,
+								Base: num.MakeAmount(10000, 2),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func vatTotal(rate cbc.Key, percent *num.Percentage) *tax.Total {
+	return &tax.Total{
+		Categories: []*tax.CategoryTotal{
+			{
+				Code: tax.CategoryVAT,
+				Rates: []*tax.RateTotal{
+					{Key: rate, Percent: percent},
+				},
+			},
+		},
+	}
 }
@@ -7,6 +7,8 @@ import (
 
 	"github.com/invopop/gobl"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
 )
 
 // Constants for KSeF XML
@@ -19,15 +21,17 @@ const (
 
 // Invoice is a pseudo-model for containing the XML document being created
 type Invoice struct {
-	XMLName      xml.Name
-	XSINamespace string  `xml:"xmlns:xsi,attr"`
-	XSDNamespace string  `xml:"xmlns:xsd,attr"`
-	XMLNamespace string  `xml:"xmlns,attr"`
-	Header       *Header `xml:"Naglowek"`
-	Seller       *Seller `xml:"Podmiot1"`
-	Buyer        *Buyer  `xml:"Podmiot2"`
-	ThirdParty   *Buyer  `xml:"Podmiot3,omitempty"` // third party
-	Inv          *Inv    `xml:"Fa"`
+	XMLName         xml.Name
+	XSINamespace    string           `xml:"xmlns:xsi,attr"`
+	XSDNamespace    string           `xml:"xmlns:xsd,attr"`
+	XMLNamespace    string           `xml:"xmlns,attr"`
+	Header          *Header          `xml:"Naglowek"`
+	Seller          *Seller          `xml:"Podmiot1"`
+	Buyer           *Buyer           `xml:"Podmiot2"`
+	ThirdParties    []*ThirdParty    `xml:"Podmiot3,omitempty"`
+	AuthorisedParty *AuthorisedParty `xml:"PodmiotUpowazniony,omitempty"`
+	Inv             *Inv             `xml:"Fa"`
+	Footer          *Footer          `xml:"Stopka,omitempty"`
 }
 
 // NewDocument converts a GOBL envelope into a FA_VAT document
@@ -37,21 +41,80 @@ func NewDocument(env *gobl.Envelope) (*Invoice, error) {
 		return nil, fmt.Errorf("invalid type %T", env.Document)
 	}
 
+	fa, err := NewInv(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	thirdParties, err := NewThirdParties(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	authorised, err := NewAuthorisedParty(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	footer, err := NewFooter(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	seller, err := NewSeller(inv.Supplier)
+	if err != nil {
+		return nil, err
+	}
+	if isIntraCommunityCustomer(inv.Customer) {
+		// intra-community supplies show the EU VAT prefix of the seller
+		seller.VATPrefix = l10n.PL.String()
+	}
+
+	buyer := NewBuyer(inv.Customer)
+	if fa.InvoiceType == invoiceTypeSimplified {
+		buyer = newSimplifiedBuyer(buyer)
+	}
+	linkCorrectedBuyers(buyer, fa.CorrectedBuyers, inv.Customer)
+
 	invoice := &Invoice{
 		XMLName:      xml.Name{Local: RootElementName},
 		XSINamespace: XSINamespace,
 		XSDNamespace: XSDNamespace,
 		XMLNamespace: XMLNamespace,
 
-		Header: NewHeader(inv),
-		Seller: NewSeller(inv.Supplier),
-		Buyer:  NewBuyer(inv.Customer),
-		Inv:    NewInv(inv),
+		Header:          NewHeader(inv),
+		Seller:          seller,
+		Buyer:           buyer,
+		ThirdParties:    thirdParties,
+		AuthorisedParty: authorised,
+		Inv:             fa,
+		Footer:          footer,
 	}
 
 	return invoice, nil
 }
 
+// ContextNIP returns the NIP of the context the document has to be sent in.
+// Enforcement authorities and bailiffs send invoices in their own context,
+// while all other invoices are sent in the context of the seller.
+func (d *Invoice) ContextNIP() string {
+	if ap := d.AuthorisedParty; ap != nil {
+		if r := cbc.Code(ap.Role); r.In(AuthorisedRoleEnforcement, AuthorisedRoleBailiff) {
+			return ap.NIP
+		}
+	}
+	return d.Seller.NIP
+}
+
+// ValidateContext checks that the document can be sent in a session opened
+// in the context of the given NIP
+func (d *Invoice) ValidateContext(nip string) error {
+	if expected := d.ContextNIP(); nip != expected {
+		return fmt.Errorf("session context NIP %s does not match the expected %s", nip, expected)
+	}
+	return nil
+}
+
 // Bytes returns the XML representation of the document in bytes
 func (d *Invoice) Bytes() ([]byte, error) {
 	data, err := xml.MarshalIndent(d, "", "  ")
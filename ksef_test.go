@@ -1,9 +1,13 @@
 package ksef_test
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 
+	ksef "github.com/invopop/gobl.ksef"
 	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	xsdvalidate "github.com/terminalstatic/go-xsd-validate"
@@ -24,100 +28,86 @@ func TestNewDocument(t *testing.T) {
 		assert.NotNil(t, doc.Inv)
 	})
 
-	t.Run("should return bytes of the KSeF document", func(t *testing.T) {
-		doc, err := test.NewDocumentFrom("invoice-pl-pl.json")
-		require.NoError(t, err)
-
-		data, err := doc.Bytes()
+	t.Run("should link the buyer to the buyer data of the corrected invoice", func(t *testing.T) {
+		inv, err := test.LoadTestInvoice("credit-note-customer.json")
 		require.NoError(t, err)
+		inv.Customer.UUID = uuid.MustParse("0190a63b-6e2a-7d4e-b3e5-3c8a4d5f6e7a")
 
-		output, err := test.LoadOutputFile("invoice-pl-pl.xml")
+		doc, err := test.GenerateKSeFFrom(inv)
 		require.NoError(t, err)
 
-		assert.Equal(t, string(output), string(data))
+		require.NotEmpty(t, doc.Inv.CorrectedBuyers)
+		assert.Equal(t, "0190a63b6e2a7d4eb3e53c8a4d5f6e7a", doc.Buyer.BuyerID)
+		assert.Equal(t, doc.Buyer.BuyerID, doc.Inv.CorrectedBuyers[0].BuyerID)
 	})
 
-	t.Run("should return bytes of the credit-note invoice", func(t *testing.T) {
-		doc, err := test.NewDocumentFrom("credit-note.json")
-		require.NoError(t, err)
-
-		data, err := doc.Bytes()
+	t.Run("should set the VAT prefix of the seller in intra-community supplies", func(t *testing.T) {
+		doc, err := test.NewDocumentFrom("invoice-intra-eu.json")
 		require.NoError(t, err)
 
-		output, err := test.LoadOutputFile("credit-note.xml")
-		require.NoError(t, err)
-
-		assert.Equal(t, string(output), string(data))
+		assert.Equal(t, "PL", doc.Seller.VATPrefix)
 	})
 
-	t.Run("should generate valid KSeF document", func(t *testing.T) {
-		err := xsdvalidate.Init()
-		require.NoError(t, err)
-		defer xsdvalidate.Cleanup()
-
-		xsdBuf, err := test.LoadSchemaFile("FA2.xsd")
-		require.NoError(t, err)
-
-		xsdhandler, err := xsdvalidate.NewXsdHandlerMem(xsdBuf, xsdvalidate.ParsErrVerbose)
-		require.NoError(t, err)
-		defer xsdhandler.Free()
-
+	t.Run("should expect the seller context", func(t *testing.T) {
 		doc, err := test.NewDocumentFrom("invoice-pl-pl.json")
 		require.NoError(t, err)
 
-		data, err := doc.Bytes()
-		require.NoError(t, err)
-
-		validation := xsdhandler.ValidateMem(data, xsdvalidate.ParsErrDefault)
-		assert.Nil(t, validation)
+		assert.NoError(t, doc.ValidateContext("1234567788"))
+		assert.ErrorContains(t, doc.ValidateContext("7980332920"), "session context NIP 7980332920 does not match the expected 1234567788")
 	})
 
-	t.Run("should generate valid credit-note", func(t *testing.T) {
-		err := xsdvalidate.Init()
-		require.NoError(t, err)
-		defer xsdvalidate.Cleanup()
-
-		xsdBuf, err := test.LoadSchemaFile("FA2.xsd")
-		require.NoError(t, err)
-
-		xsdhandler, err := xsdvalidate.NewXsdHandlerMem(xsdBuf, xsdvalidate.ParsErrVerbose)
+	t.Run("should expect the bailiff context", func(t *testing.T) {
+		doc, err := test.NewDocumentFrom("invoice-pl-pl.json")
 		require.NoError(t, err)
-		defer xsdhandler.Free()
+		doc.AuthorisedParty = &ksef.AuthorisedParty{NIP: "7980332920", Role: "2"}
 
-		doc, err := test.NewDocumentFrom("credit-note.json")
-		require.NoError(t, err)
+		assert.NoError(t, doc.ValidateContext("7980332920"))
+		assert.Error(t, doc.ValidateContext("1234567788"))
+	})
 
-		data, err := doc.Bytes()
+	t.Run("should expect the seller context with a tax representative", func(t *testing.T) {
+		doc, err := test.NewDocumentFrom("invoice-pl-pl.json")
 		require.NoError(t, err)
+		doc.AuthorisedParty = &ksef.AuthorisedParty{NIP: "7980332920", Role: "3"}
 
-		validation := xsdhandler.ValidateMem(data, xsdvalidate.ParsErrDefault)
-		assert.Nil(t, validation)
+		assert.NoError(t, doc.ValidateContext("1234567788"))
 	})
+}
 
-	t.Run("should generate valid self-billed invoice", func(t *testing.T) {
-		err := xsdvalidate.Init()
-		require.NoError(t, err)
-		defer xsdvalidate.Cleanup()
+func TestNewDocumentFixtures(t *testing.T) {
+	err := xsdvalidate.Init()
+	require.NoError(t, err)
+	defer xsdvalidate.Cleanup()
 
-		xsdBuf, err := test.LoadSchemaFile("FA2.xsd")
-		require.NoError(t, err)
+	xsdBuf, err := test.LoadSchemaFile("FA2.xsd")
+	require.NoError(t, err)
 
-		xsdhandler, err := xsdvalidate.NewXsdHandlerMem(xsdBuf, xsdvalidate.ParsErrVerbose)
-		require.NoError(t, err)
+	xsdhandler, xsdErr := xsdvalidate.NewXsdHandlerMem(xsdBuf, xsdvalidate.ParsErrVerbose)
+	if xsdErr == nil {
 		defer xsdhandler.Free()
+	}
 
-		doc, err := test.NewDocumentFrom("invoice-self-billed.json")
-		require.NoError(t, err)
+	fixtures, err := filepath.Glob(filepath.Join(test.GetDataPath(), "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
 
-		data, err := doc.Bytes()
-		require.NoError(t, err)
+	for _, path := range fixtures {
+		name := filepath.Base(path)
+		t.Run(name, func(t *testing.T) {
+			doc, err := test.NewDocumentFrom(name)
+			require.NoError(t, err)
 
-		validation := xsdhandler.ValidateMem(data, xsdvalidate.ParsErrDefault)
-		assert.Nil(t, validation)
+			data, err := doc.Bytes()
+			require.NoError(t, err)
 
-		output, err := test.LoadOutputFile("invoice-self-billed.xml")
-		require.NoError(t, err)
+			output, err := test.LoadOutputFile(strings.TrimSuffix(name, ".json") + ".xml")
+			require.NoError(t, err)
 
-		assert.Equal(t, string(output), string(data))
-	})
+			assert.Equal(t, string(output), string(data))
+
+			require.NoError(t, xsdErr)
+			validation := xsdhandler.ValidateMem(data, xsdvalidate.ParsErrDefault)
+			assert.Nil(t, validation)
+		})
+	}
 }
@@ -1,64 +1,275 @@
 package ksef
 
 import (
+	"fmt"
+
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 )
 
 // Line defines the XML structure for KSeF item line
 type Line struct {
 	LineNumber              int    `xml:"NrWierszaFa"`
+	UUID                    string `xml:"UU_ID,omitempty"`
+	CompletionDate          string `xml:"P_6A,omitempty"`
 	Name                    string `xml:"P_7,omitempty"`
+	Index                   string `xml:"Indeks,omitempty"`
+	GTIN                    string `xml:"GTIN,omitempty"`
+	PKWiU                   string `xml:"PKWiU,omitempty"`
+	CN                      string `xml:"CN,omitempty"`
+	PKOB                    string `xml:"PKOB,omitempty"`
 	Measure                 string `xml:"P_8A,omitempty"`
 	Quantity                string `xml:"P_8B,omitempty"`
 	NetUnitPrice            string `xml:"P_9A,omitempty"`
+	GrossUnitPrice          string `xml:"P_9B,omitempty"`
 	UnitDiscount            string `xml:"P_10,omitempty"`
 	NetPriceTotal           string `xml:"P_11,omitempty"`
+	GrossPriceTotal         string `xml:"P_11A,omitempty"`
+	VATAmount               string `xml:"P_11Vat,omitempty"`
 	VATRate                 string `xml:"P_12,omitempty"`
-	ExciseDuty              string `xml:"KwotaAkcyzy,omitempty"`
-	SpecialGoodsCode        string `xml:"GTU,omitempty"` // values GTU_1 to GTU_13
 	OSSTaxRate              string `xml:"P_12_XII,omitempty"`
 	Attachment15GoodsMarker string `xml:"P_12_Zal_15,omitempty"`
+	ExciseDuty              string `xml:"KwotaAkcyzy,omitempty"`
+	SpecialGoodsCode        string `xml:"GTU,omitempty"` // values GTU_01 to GTU_13
 	Procedure               string `xml:"Procedura,omitempty"`
+	ExchangeRate            string `xml:"KursWaluty,omitempty"`
 	BeforeCorrectionMarker  string `xml:"StanPrzed,omitempty"`
 }
 
+// procedureDistanceSales is the procedure code of intra-community distance
+// sales under the OSS procedure
+const procedureDistanceSales = "WSTO_EE"
+
 func newLine(line *bill.Line) *Line {
 	l := &Line{
-		LineNumber:    line.Index,
-		Name:          line.Item.Name,
-		Measure:       string(line.Item.Unit.UNECE()),
-		NetUnitPrice:  line.Item.Price.String(),
-		Quantity:      line.Quantity.String(),
-		UnitDiscount:  unitDiscount(line),
-		NetPriceTotal: line.Total.String(),
+		LineNumber:       line.Index,
+		Name:             line.Item.Name,
+		Index:            line.Item.Ref.String(),
+		GTIN:             itemIdentity(line.Item, IdentityTypeGTIN),
+		PKWiU:            itemIdentity(line.Item, IdentityTypePKWiU),
+		CN:               itemIdentity(line.Item, IdentityTypeCN),
+		PKOB:             itemIdentity(line.Item, IdentityTypePKOB),
+		Measure:          string(line.Item.Unit.UNECE()),
+		NetUnitPrice:     line.Item.Price.String(),
+		Quantity:         line.Quantity.String(),
+		UnitDiscount:     unitDiscount(line.Discounts, line.Quantity),
+		NetPriceTotal:    line.Total.String(),
+		ExciseDuty:       exciseDuty(line.Charges),
+		SpecialGoodsCode: line.Item.Ext.Get(ExtKeyGTU).String(),
+		Procedure:        line.Ext.Get(ExtKeyProcedure).String(),
+	}
+	if !line.UUID.IsZero() {
+		l.UUID = line.UUID.String()
+	}
+	if line.Period != nil {
+		l.CompletionDate = line.Period.End.String()
+	}
+	if line.Item.Ext.Get(ExtKeyAnnex15) == "1" {
+		l.Attachment15GoodsMarker = "1"
 	}
 	if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil {
-		if tc.Percent != nil {
-			l.VATRate = tc.Percent.Rescale(2).StringWithoutSymbol()
+		setVATRate(l, tc)
+		if l.OSSTaxRate != "" && l.Procedure == "" {
+			// sales charged with the VAT of the customer's member state
+			// are intra-community distance sales
+			l.Procedure = procedureDistanceSales
+		}
+		if tc.Ext.Has(ExtKeyMarginScheme) {
+			// prices under a margin scheme include the VAT on the margin,
+			// which is not shown on the invoice
+			l.GrossUnitPrice = l.NetUnitPrice
+			l.GrossPriceTotal = l.NetPriceTotal
+			l.NetUnitPrice = ""
+			l.NetPriceTotal = ""
 		}
 	}
 
 	return l
 }
 
-func unitDiscount(line *bill.Line) string {
-	if len(line.Discounts) == 0 {
+// setVATRate sets the VAT rate of the line, which is reported separately
+// when it is the rate of another member state charged under the OSS
+// procedure
+func setVATRate(l *Line, tc *tax.Combo) {
+	if tc.Percent == nil {
+		return
+	}
+	rate := tc.Percent.Amount().MinimalString()
+	if isOSSCountry(tc.Country) {
+		l.OSSTaxRate = rate
+		return
+	}
+	l.VATRate = rate
+}
+
+// setVATCodes sets the textual VAT rate codes of the lines taxed at rates
+// without a percentage, classified in the same way as the summary fields.
+// Lines under a margin scheme have no rate.
+func setVATCodes(lines []*Line, src []*bill.Line, vc *vatContext) {
+	combos := make(map[int]*tax.Combo)
+	for _, line := range src {
+		if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil {
+			combos[line.Index] = tc
+		}
+	}
+
+	for _, l := range lines {
+		tc := combos[l.LineNumber]
+		if tc == nil || tc.Percent != nil {
+			continue
+		}
+		switch newVATGroup(tc.Rate, tc.Country, tc.Ext, vc) {
+		case vatGroupExempt:
+			l.VATRate = vatRateExempt
+		case vatGroupReverseCharge:
+			l.VATRate = vatRateReverseCharge
+		case vatGroupNotPursuant, vatGroupNotPursuantArt100:
+			l.VATRate = vatRateNotPursuant
+		}
+	}
+}
+
+// itemIdentity returns the code of the first identity of the item with the
+// given type
+func itemIdentity(item *org.Item, typ cbc.Code) string {
+	for _, id := range item.Identities {
+		if id.Type == typ {
+			return id.Code.String()
+		}
+	}
+	return ""
+}
+
+// exciseDuty sums the excise duty charges of a line
+func exciseDuty(charges []*bill.LineCharge) string {
+	var amount *num.Amount
+	for _, c := range charges {
+		if c.Key != ChargeKeyExcise {
+			continue
+		}
+		if amount == nil {
+			amount = &c.Amount
+			continue
+		}
+		sum := amount.Add(c.Amount)
+		amount = &sum
+	}
+	if amount == nil {
+		return ""
+	}
+	return amount.String()
+}
+
+// validateLines checks the extensions and Polish VAT rates of the invoice
+// lines. GOBL validates the extensions of line items, but not those of the
+// lines themselves, such as the procedure.
+func validateLines(lines []*bill.Line) error {
+	for _, line := range lines {
+		if err := line.Ext.Validate(); err != nil {
+			return fmt.Errorf("invalid extensions in line %d: %w", line.Index, err)
+		}
+		if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil && tc.Percent != nil && !isOSSCountry(tc.Country) {
+			if rate := tc.Percent.Amount().MinimalString(); !containsString(vatRateCodes, rate) {
+				return fmt.Errorf("invalid VAT rate '%s' in line %d", rate, line.Index)
+			}
+		}
+	}
+	return nil
+}
+
+func unitDiscount(discounts []*bill.LineDiscount, quantity num.Amount) string {
+	if len(discounts) == 0 {
 		return ""
 	}
 
 	amount := num.MakeAmount(0, 2)
 
-	for _, discount := range line.Discounts {
+	for _, discount := range discounts {
 		amount = amount.Add(discount.Amount)
 	}
 
-	discount := amount.Divide(line.Quantity)
+	discount := amount.Divide(quantity)
 
 	return discount.String()
 }
 
+// pricesIncludeVAT reports whether the prices of the invoice include VAT
+func pricesIncludeVAT(inv *bill.Invoice) bool {
+	return inv.Tax != nil && inv.Tax.PricesInclude == tax.CategoryVAT
+}
+
+// useGrossPrices moves the unit prices and values of the lines to the gross
+// fields, as the prices of the invoice include VAT and the tax is only
+// calculated on the totals
+func useGrossPrices(lines []*Line) {
+	for _, l := range lines {
+		if l.NetUnitPrice != "" {
+			l.GrossUnitPrice = l.NetUnitPrice
+			l.NetUnitPrice = ""
+		}
+		if l.NetPriceTotal != "" {
+			l.GrossPriceTotal = l.NetPriceTotal
+			l.NetPriceTotal = ""
+		}
+	}
+}
+
+// Names of the lines and settlements of invoice discounts and charges given
+// without a reason
+const (
+	discountLineName = "Rabat"
+	chargeLineName   = "Obciążenie"
+)
+
+// documentLines generates lines for the discounts and charges of the whole
+// invoice, which FA(2) can only report as additional lines numbered after
+// the invoice lines. Discounts have negative values, so that the lines still
+// add up to the summary fields. Those without VAT are left for the
+// settlement.
+func documentLines(inv *bill.Invoice) []*bill.Line {
+	n := 0
+	if len(inv.Lines) > 0 {
+		n = inv.Lines[len(inv.Lines)-1].Index
+	}
+
+	var lines []*bill.Line
+	for _, d := range inv.Discounts {
+		if isSettlement(d.Taxes) {
+			continue
+		}
+		n++
+		lines = append(lines, documentLine(n, d.Reason, discountLineName, d.Amount.Negate(), d.Taxes))
+	}
+	for _, c := range inv.Charges {
+		if isSettlement(c.Taxes) {
+			continue
+		}
+		n++
+		lines = append(lines, documentLine(n, c.Reason, chargeLineName, c.Amount, c.Taxes))
+	}
+
+	return lines
+}
+
+func documentLine(index int, reason, name string, amount num.Amount, taxes tax.Set) *bill.Line {
+	if reason != "" {
+		name = reason
+	}
+	return &bill.Line{
+		Index:    index,
+		Quantity: num.MakeAmount(1, 0),
+		Item: &org.Item{
+			Name:  name,
+			Price: &amount,
+		},
+		Total: &amount,
+		Taxes: taxes,
+	}
+}
+
 // NewLines generates lines for the KSeF invoice
 func NewLines(lines []*bill.Line) []*Line {
 	var Lines []*Line
@@ -69,3 +280,41 @@ func NewLines(lines []*bill.Line) []*Line {
 
 	return Lines
 }
+
+// newBeforeCorrectionLine generates a line with the state before the
+// correction from a line substituted by a corrected line. Substituted lines
+// have no taxes, so they keep the VAT rate of the corrected line, checked
+// against the corrected invoice by checkSubstitutedRates.
+func newBeforeCorrectionLine(line *bill.Line, sub *bill.SubLine) *Line {
+	l := &Line{
+		LineNumber:             line.Index,
+		Name:                   sub.Item.Name,
+		Measure:                string(sub.Item.Unit.UNECE()),
+		NetUnitPrice:           sub.Item.Price.String(),
+		Quantity:               sub.Quantity.String(),
+		UnitDiscount:           unitDiscount(sub.Discounts, sub.Quantity),
+		NetPriceTotal:          sub.Total.String(),
+		BeforeCorrectionMarker: "1",
+	}
+	if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil {
+		setVATRate(l, tc)
+	}
+
+	return l
+}
+
+// NewCorrectionLines generates lines for the KSeF correction invoice. Lines
+// that substitute others are preceded by the substituted lines, marked as
+// the state before the correction.
+func NewCorrectionLines(lines []*bill.Line) []*Line {
+	var Lines []*Line
+
+	for _, line := range lines {
+		for _, sub := range line.Substituted {
+			Lines = append(Lines, newBeforeCorrectionLine(line, sub))
+		}
+		Lines = append(Lines, newLine(line))
+	}
+
+	return Lines
+}
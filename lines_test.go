@@ -2,12 +2,15 @@ package ksef_test
 
 import (
 	"testing"
+	"time"
 
 	ksef "github.com/invopop/gobl.ksef"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -114,4 +117,52 @@ func TestNewLines(t *testing.T) {
 
 		assert.Equal(t, "200.00", ln[0].UnitDiscount)
 	})
+
+	t.Run("maps item identities, extensions and excise duty", func(t *testing.T) {
+		lines := []*bill.Line{
+			{
+				Identify: uuid.Identify{UUID: "0190f5c4-6d3e-7a2b-9c1d-2f3e4a5b6c7d"},
+				Index:    1,
+				Period: &cal.Period{
+					Start: cal.MakeDate(2024, time.March, 1),
+					End:   cal.MakeDate(2024, time.March, 10),
+				},
+				Item: &org.Item{
+					Ref:   "FUEL-95",
+					Name:  "Petrol 95",
+					Price: num.NewAmount(600, 2),
+					Identities: []*org.Identity{
+						{Type: ksef.IdentityTypeGTIN, Code: "5901234123457"},
+						{Type: ksef.IdentityTypePKWiU, Code: "19.20.21.0"},
+						{Type: ksef.IdentityTypeCN, Code: "2710 12 45"},
+					},
+					Ext: tax.Extensions{
+						ksef.ExtKeyGTU:     "GTU_02",
+						ksef.ExtKeyAnnex15: "1",
+					},
+				},
+				Quantity: num.MakeAmount(100, 0),
+				Charges: []*bill.LineCharge{
+					{Key: ksef.ChargeKeyExcise, Amount: num.MakeAmount(14500, 2)},
+					{Key: bill.ChargeKeyDelivery, Amount: num.MakeAmount(1000, 2)},
+				},
+				Total: num.NewAmount(61000, 2),
+				Ext:   tax.Extensions{ksef.ExtKeyProcedure: "TT_D"},
+			},
+		}
+
+		ln := ksef.NewLines(lines)
+
+		assert.Equal(t, "0190f5c4-6d3e-7a2b-9c1d-2f3e4a5b6c7d", ln[0].UUID)
+		assert.Equal(t, "2024-03-10", ln[0].CompletionDate)
+		assert.Equal(t, "FUEL-95", ln[0].Index)
+		assert.Equal(t, "5901234123457", ln[0].GTIN)
+		assert.Equal(t, "19.20.21.0", ln[0].PKWiU)
+		assert.Equal(t, "2710 12 45", ln[0].CN)
+		assert.Empty(t, ln[0].PKOB)
+		assert.Equal(t, "1", ln[0].Attachment15GoodsMarker)
+		assert.Equal(t, "145.00", ln[0].ExciseDuty)
+		assert.Equal(t, "GTU_02", ln[0].SpecialGoodsCode)
+		assert.Equal(t, "TT_D", ln[0].Procedure)
+	})
 }
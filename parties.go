@@ -1,10 +1,22 @@
 package ksef
 
 import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 )
 
+// euCountryCodes lists the tax country codes accepted as KodUE, including
+// Northern Ireland
+var euCountryCodes = []l10n.TaxCountryCode{
+	"AT", "BE", "BG", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "EL", "HR", "HU", "IE",
+	"IT", "LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE", "XI",
+}
+
 // Address defines the XML structure for KSeF addresses
 type Address struct {
 	CountryCode string `xml:"KodKraju"`
@@ -12,35 +24,120 @@ type Address struct {
 	AddressL2   string `xml:"AdresL2,omitempty"`
 }
 
+// maxContacts is the number of contacts KSeF parties allow
+const maxContacts = 3
+
 // Seller defines the XML structure for KSeF seller
 type Seller struct {
-	NIP     string          `xml:"DaneIdentyfikacyjne>NIP"`
-	Name    string          `xml:"DaneIdentyfikacyjne>Nazwa"`
-	Address *Address        `xml:"Adres"`
-	Contact *ContactDetails `xml:"DaneKontaktowe,omitempty"`
+	VATPrefix             string            `xml:"PrefiksPodatnika,omitempty"`
+	EORI                  string            `xml:"NrEORI,omitempty"`
+	NIP                   string            `xml:"DaneIdentyfikacyjne>NIP"`
+	Name                  string            `xml:"DaneIdentyfikacyjne>Nazwa"`
+	Address               *Address          `xml:"Adres"`
+	CorrespondenceAddress *Address          `xml:"AdresKoresp,omitempty"`
+	Contacts              []*ContactDetails `xml:"DaneKontaktowe,omitempty"`
+	TaxpayerStatus        string            `xml:"StatusInfoPodatnika,omitempty"`
 }
 
 // ContactDetails defines the XML structure for KSeF contact
 type ContactDetails struct {
-	Phone string `xml:"Telefon,omitempty"`
 	Email string `xml:"Email,omitempty"`
+	Phone string `xml:"Telefon,omitempty"`
 }
 
 // Buyer defines the XML structure for KSeF buyer
 type Buyer struct {
+	EORI string `xml:"NrEORI,omitempty"`
+
+	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	// or
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	// or
+	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	// or
+	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+
+	Name                  string            `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address               *Address          `xml:"Adres,omitempty"`
+	CorrespondenceAddress *Address          `xml:"AdresKoresp,omitempty"`
+	Contacts              []*ContactDetails `xml:"DaneKontaktowe,omitempty"`
+	CustomerNumber        string            `xml:"NrKlienta,omitempty"`
+	BuyerID               string            `xml:"IDNabywcy,omitempty"`
+}
+
+// ThirdParty defines the XML structure for KSeF third party (Podmiot3)
+type ThirdParty struct {
 	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
 	// or
-	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"` //TODO
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
 	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
 	// or
 	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
-	IDNumber    string `xml:"DaneIdentyfikacyjne>NrId,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
 	// or
 	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
 
-	Name    string          `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
-	Address *Address        `xml:"Adres,omitempty"`
-	Contact *ContactDetails `xml:"DaneKontaktowe,omitempty"`
+	Name     string            `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address  *Address          `xml:"Adres"`
+	Contacts []*ContactDetails `xml:"DaneKontaktowe,omitempty"`
+
+	Role string `xml:"Rola,omitempty"`
+	// or
+	OtherRoleMarker int    `xml:"RolaInna,omitempty"`
+	RoleDescription string `xml:"OpisRoli,omitempty"`
+
+	Share string `xml:"Udzial,omitempty"`
+}
+
+// AuthorisedParty defines the XML structure for the KSeF authorised entity
+// (PodmiotUpowazniony)
+type AuthorisedParty struct {
+	NIP     string                    `xml:"DaneIdentyfikacyjne>NIP"`
+	Name    string                    `xml:"DaneIdentyfikacyjne>Nazwa"`
+	Address *Address                  `xml:"Adres"`
+	Contact *AuthorisedContactDetails `xml:"DaneKontaktowe,omitempty"`
+	Role    string                    `xml:"RolaPU"`
+}
+
+// AuthorisedContactDetails defines the XML structure for KSeF authorised
+// entity contact
+type AuthorisedContactDetails struct {
+	Email string `xml:"EmailPU,omitempty"`
+	Phone string `xml:"TelefonPU,omitempty"`
+}
+
+// authorisedRoles lists the valid role codes of an authorised entity
+var authorisedRoles = []cbc.Code{
+	AuthorisedRoleEnforcement,
+	AuthorisedRoleBailiff,
+	AuthorisedRoleTaxRepresentative,
+}
+
+// taxpayerStatuses lists the valid taxpayer status codes of the seller
+var taxpayerStatuses = []cbc.Code{
+	TaxpayerStatusLiquidation,
+	TaxpayerStatusRestructuring,
+	TaxpayerStatusBankruptcy,
+	TaxpayerStatusInheritance,
+}
+
+// receiverRoleDescription describes delivery receivers with no role
+const receiverRoleDescription = "Odbiorca"
+
+// thirdPartyRoles lists the valid role codes of a third party
+var thirdPartyRoles = []cbc.Code{
+	ThirdPartyRoleFactor,
+	ThirdPartyRoleRecipient,
+	ThirdPartyRoleOriginal,
+	ThirdPartyRoleBuyer,
+	ThirdPartyRoleIssuer,
+	ThirdPartyRolePayer,
+	ThirdPartyRoleLocalGovIssuer,
+	ThirdPartyRoleLocalGovReceiver,
+	ThirdPartyRoleVATGroupIssuer,
+	ThirdPartyRoleVATGroupReceiver,
 }
 
 // newAddress gets the address data from GOBL address
@@ -54,6 +151,51 @@ func newAddress(address *org.Address) *Address {
 	return adres
 }
 
+// newCorrespondenceAddress gets the second address of a GOBL party, used as
+// the correspondence address
+func newCorrespondenceAddress(party *org.Party) *Address {
+	if len(party.Addresses) < 2 {
+		return nil
+	}
+	return newAddress(party.Addresses[1])
+}
+
+// newContactDetails pairs the emails, followed by the email inboxes, and
+// phones of a GOBL party into at most three contacts
+func newContactDetails(party *org.Party) []*ContactDetails {
+	var emails []string
+	for _, e := range party.Emails {
+		emails = append(emails, e.Address)
+	}
+	for _, in := range party.Inboxes {
+		if in.Email != "" {
+			emails = append(emails, in.Email)
+		}
+	}
+
+	var contacts []*ContactDetails
+	for i := 0; i < maxContacts && (i < len(emails) || i < len(party.Telephones)); i++ {
+		c := new(ContactDetails)
+		if i < len(emails) {
+			c.Email = emails[i]
+		}
+		if i < len(party.Telephones) {
+			c.Phone = party.Telephones[i].Number
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts
+}
+
+// partyIdentity gets the code of the first identity of a GOBL party with
+// the given type
+func partyIdentity(party *org.Party, typ cbc.Code) string {
+	if id := org.IdentityForType(party.Identities, typ); id != nil {
+		return id.Code.String()
+	}
+	return ""
+}
+
 // nameToString get the seller name out of the organization
 func nameToString(name *org.Name) string {
 	return name.Prefix + nameMaybe(name.Given) +
@@ -62,7 +204,7 @@ func nameToString(name *org.Name) string {
 }
 
 // NewSeller converts a GOBL Party into a KSeF seller
-func NewSeller(supplier *org.Party) *Seller {
+func NewSeller(supplier *org.Party) (*Seller, error) {
 	var name string
 	if supplier.Name != "" {
 		name = supplier.Name
@@ -70,62 +212,207 @@ func NewSeller(supplier *org.Party) *Seller {
 		name = nameToString(supplier.People[0].Name)
 	}
 	seller := &Seller{
-		Address: newAddress(supplier.Addresses[0]),
-		NIP:     string(supplier.TaxID.Code),
-		Name:    name,
-	}
-	if len(supplier.Telephones) > 0 {
-		seller.Contact = &ContactDetails{
-			Phone: supplier.Telephones[0].Number,
-		}
+		EORI:                  partyIdentity(supplier, IdentityTypeEORI),
+		Address:               newAddress(supplier.Addresses[0]),
+		CorrespondenceAddress: newCorrespondenceAddress(supplier),
+		NIP:                   string(supplier.TaxID.Code),
+		Name:                  name,
 	}
-	if len(supplier.Emails) > 0 {
-		if seller.Contact == nil {
-			seller.Contact = &ContactDetails{}
+	seller.Contacts = newContactDetails(supplier)
+
+	if s := supplier.Ext.Get(ExtKeyTaxpayerStatus); s != "" {
+		if !s.In(taxpayerStatuses...) {
+			return nil, fmt.Errorf("invalid taxpayer status '%s'", s)
 		}
-		seller.Contact.Email = supplier.Emails[0].Address
+		seller.TaxpayerStatus = s.String()
 	}
 
-	return seller
+	return seller, nil
 }
 
-// NewBuyer converts a GOBL Party into a KSeF buyer
+// NewBuyer converts a GOBL Party into a KSeF buyer. Invoices with no
+// customer, or customers without a tax ID, are issued with the no ID marker.
 func NewBuyer(customer *org.Party) *Buyer {
+	if customer == nil {
+		return &Buyer{NoID: 1}
+	}
 
 	buyer := &Buyer{
-		Name: customer.Name,
-		NIP:  string(customer.TaxID.Code),
+		EORI:           partyIdentity(customer, IdentityTypeEORI),
+		Name:           customer.Name,
+		CustomerNumber: partyIdentity(customer, IdentityTypeCustomerNumber),
 	}
 
-	if customer.TaxID.Country == l10n.PL.Tax() {
+	switch {
+	case customer.TaxID == nil || customer.TaxID.Code == "":
+		buyer.NoID = 1
+	case customer.TaxID.Country == l10n.PL.Tax():
 		buyer.NIP = string(customer.TaxID.Code)
-	} else {
-		if len(customer.TaxID.Code) > 0 {
-			buyer.IDNumber = string(customer.TaxID.Code)
-			buyer.CountryCode = string(customer.TaxID.Country)
-		} else {
-			buyer.NoID = 1
-		}
+	case isEUCountry(customer.TaxID.Country):
+		buyer.UECode = string(customer.TaxID.Country)
+		buyer.UEVatNumber = string(customer.TaxID.Code)
+	default:
+		buyer.CountryCode = string(customer.TaxID.Country)
+		buyer.IDNumber = string(customer.TaxID.Code)
 	}
-	// TODO NrVatUE and UECode if applicable
 
 	if len(customer.Addresses) > 0 {
 		buyer.Address = newAddress(customer.Addresses[0])
 	}
 
-	if len(customer.Telephones) > 0 {
-		buyer.Contact = &ContactDetails{
-			Phone: customer.Telephones[0].Number,
+	buyer.CorrespondenceAddress = newCorrespondenceAddress(customer)
+	buyer.Contacts = newContactDetails(customer)
+
+	return buyer
+}
+
+// NewThirdParties converts the GOBL parties involved in the invoice other
+// than the supplier and customer into KSeF third parties. The ordering buyer
+// gets the payer role by default, the delivery receiver is reported as an
+// other entity described as the receiver unless its role is set with the
+// ExtKeyThirdPartyRole extension or described by its label, and the payee
+// is only reported with a role or a label. The ordering seller is never a third party, and
+// is only reported as the authorised entity when it has a role.
+func NewThirdParties(inv *bill.Invoice) ([]*ThirdParty, error) {
+	var parties []*ThirdParty
+
+	add := func(party *org.Party, role cbc.Code, otherRole string) error {
+		if party == nil {
+			return nil
+		}
+		tp, err := newThirdParty(party, role, otherRole)
+		if err != nil {
+			return err
 		}
+		parties = append(parties, tp)
+		return nil
 	}
-	if len(customer.Emails) > 0 {
-		if buyer.Contact == nil {
-			buyer.Contact = &ContactDetails{}
+
+	if inv.Payment != nil && hasThirdPartyRole(inv.Payment.Payee) {
+		// the payee is only a factor when set explicitly
+		if err := add(inv.Payment.Payee, "", ""); err != nil {
+			return nil, err
+		}
+	}
+	if inv.Ordering != nil {
+		if err := add(inv.Ordering.Buyer, ThirdPartyRolePayer, ""); err != nil {
+			return nil, err
+		}
+	}
+	if inv.Delivery != nil {
+		// the receiver may be a branch of the buyer, another buyer or an
+		// unrelated party, so it is only described as the receiver
+		if err := add(inv.Delivery.Receiver, "", receiverRoleDescription); err != nil {
+			return nil, err
 		}
-		buyer.Contact.Email = customer.Emails[0].Address
 	}
 
-	return buyer
+	return parties, nil
+}
+
+// NewThirdParty converts a GOBL Party into a KSeF third party with the
+// given default role. Parties with no role are reported as other entities
+// described by their label.
+func NewThirdParty(party *org.Party, role cbc.Code) (*ThirdParty, error) {
+	return newThirdParty(party, role, "")
+}
+
+// newThirdParty converts a GOBL Party into a KSeF third party, falling back
+// to an other entity with the given description when the party has no role
+// or label
+func newThirdParty(party *org.Party, role cbc.Code, otherRole string) (*ThirdParty, error) {
+	if len(party.Addresses) == 0 {
+		return nil, fmt.Errorf("missing address for third party '%s'", party.Name)
+	}
+
+	tp := &ThirdParty{
+		Name:     party.Name,
+		Address:  newAddress(party.Addresses[0]),
+		Contacts: newContactDetails(party),
+	}
+
+	b := NewBuyer(party)
+	tp.NIP = b.NIP
+	tp.UECode = b.UECode
+	tp.UEVatNumber = b.UEVatNumber
+	tp.CountryCode = b.CountryCode
+	tp.IDNumber = b.IDNumber
+	tp.NoID = b.NoID
+
+	if r := party.Ext.Get(ExtKeyThirdPartyRole); r != "" {
+		role = r
+	}
+	switch {
+	case role != "":
+		if !role.In(thirdPartyRoles...) {
+			return nil, fmt.Errorf("invalid third party role '%s'", role)
+		}
+		tp.Role = role.String()
+	case party.Label != "":
+		tp.OtherRoleMarker = 1
+		tp.RoleDescription = party.Label
+	case otherRole != "":
+		tp.OtherRoleMarker = 1
+		tp.RoleDescription = otherRole
+	default:
+		return nil, fmt.Errorf("missing role for third party '%s'", party.Name)
+	}
+
+	if s := party.Ext.Get(ExtKeyThirdPartyShare); s != "" {
+		share, err := num.AmountFromString(s.String())
+		if err != nil || share.IsNegative() || share.Compare(num.MakeAmount(100, 0)) > 0 {
+			return nil, fmt.Errorf("invalid third party share '%s'", s)
+		}
+		tp.Share = share.String()
+	}
+
+	return tp, nil
+}
+
+// hasThirdPartyRole checks if a party has a third party role set by the
+// ExtKeyThirdPartyRole extension or described by its label
+func hasThirdPartyRole(party *org.Party) bool {
+	return party != nil && (party.Ext.Has(ExtKeyThirdPartyRole) || party.Label != "")
+}
+
+// NewAuthorisedParty converts the ordering seller of the invoice into the
+// KSeF authorised entity issuing the invoice instead of the supplier, when
+// its role is set by the ExtKeyAuthorisedRole extension. Ordering sellers
+// without it are not reported.
+func NewAuthorisedParty(inv *bill.Invoice) (*AuthorisedParty, error) {
+	if inv.Ordering == nil || inv.Ordering.Seller == nil {
+		return nil, nil
+	}
+	party := inv.Ordering.Seller
+
+	role := party.Ext.Get(ExtKeyAuthorisedRole)
+	if role == "" {
+		return nil, nil
+	}
+	if !role.In(authorisedRoles...) {
+		return nil, fmt.Errorf("invalid authorised entity role '%s'", role)
+	}
+	if party.TaxID == nil || party.TaxID.Country != l10n.PL.Tax() || party.TaxID.Code == "" {
+		return nil, fmt.Errorf("missing polish tax ID for authorised entity '%s'", party.Name)
+	}
+	if len(party.Addresses) == 0 {
+		return nil, fmt.Errorf("missing address for authorised entity '%s'", party.Name)
+	}
+
+	ap := &AuthorisedParty{
+		NIP:     party.TaxID.Code.String(),
+		Name:    party.Name,
+		Address: newAddress(party.Addresses[0]),
+		Role:    role.String(),
+	}
+	if c := newContactDetails(party); len(c) > 0 {
+		ap.Contact = &AuthorisedContactDetails{
+			Email: c[0].Email,
+			Phone: c[0].Phone,
+		}
+	}
+
+	return ap, nil
 }
 
 func addressLine1(address *org.Address) string {
@@ -157,3 +444,21 @@ func nameMaybe(element string) string {
 	}
 	return ""
 }
+
+func isEUCountry(country l10n.TaxCountryCode) bool {
+	for _, c := range euCountryCodes {
+		if c == country {
+			return true
+		}
+	}
+	return false
+}
+
+// isIntraCommunityCustomer checks if the customer is identified with a VAT
+// number of another EU member state
+func isIntraCommunityCustomer(customer *org.Party) bool {
+	if customer == nil || customer.TaxID == nil || customer.TaxID.Code == "" {
+		return false
+	}
+	return customer.TaxID.Country != l10n.PL.Tax() && isEUCountry(customer.TaxID.Country)
+}
@@ -0,0 +1,441 @@
+package ksef_test
+
+import (
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSeller(t *testing.T) {
+	supplier := func() *org.Party {
+		return &org.Party{
+			Name: "Provide One Sp. z o.o.",
+			TaxID: &tax.Identity{
+				Country: "PL",
+				Code:    "1234567788",
+			},
+			Addresses: []*org.Address{
+				{Street: "Prosta", Number: "1", Code: "00-001", Locality: "Warszawa", Country: "PL"},
+			},
+		}
+	}
+
+	t.Run("sets the EORI, taxpayer status and correspondence address", func(t *testing.T) {
+		party := supplier()
+		party.Identities = []*org.Identity{{Type: ksef.IdentityTypeEORI, Code: "PL123456778800000"}}
+		party.Addresses = append(party.Addresses, &org.Address{Street: "Krzywa", Number: "2", Code: "00-002", Locality: "Warszawa", Country: "PL"})
+		party.Ext = tax.Extensions{ksef.ExtKeyTaxpayerStatus: ksef.TaxpayerStatusLiquidation}
+		require.NoError(t, party.Validate())
+
+		seller, err := ksef.NewSeller(party)
+		require.NoError(t, err)
+
+		assert.Equal(t, "PL123456778800000", seller.EORI)
+		assert.Equal(t, "1", seller.TaxpayerStatus)
+		assert.Equal(t, &ksef.Address{CountryCode: "PL", AddressL1: "Krzywa, 2", AddressL2: "00-002, Warszawa"}, seller.CorrespondenceAddress)
+		assert.Empty(t, seller.VATPrefix)
+	})
+
+	t.Run("sets at most three contacts from emails, inboxes and phones", func(t *testing.T) {
+		party := supplier()
+		party.Emails = []*org.Email{{Address: "billing@example.com"}, {Address: "office@example.com"}}
+		party.Inboxes = []*org.Inbox{{Email: "invoices@example.com"}, {Email: "archive@example.com"}}
+		party.Telephones = []*org.Telephone{{Number: "+48 22 123 45 67"}}
+
+		seller, err := ksef.NewSeller(party)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*ksef.ContactDetails{
+			{Email: "billing@example.com", Phone: "+48 22 123 45 67"},
+			{Email: "office@example.com"},
+			{Email: "invoices@example.com"},
+		}, seller.Contacts)
+	})
+
+	t.Run("fails with an invalid taxpayer status", func(t *testing.T) {
+		party := supplier()
+		party.Ext = tax.Extensions{ksef.ExtKeyTaxpayerStatus: "5"}
+		assert.ErrorContains(t, party.Validate(), "pl-ksef-taxpayer-status")
+
+		_, err := ksef.NewSeller(party)
+		assert.ErrorContains(t, err, "invalid taxpayer status '5'")
+	})
+}
+
+func TestNewBuyer(t *testing.T) {
+	t.Run("sets NIP for polish customers", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Sample Consumer",
+			TaxID: &tax.Identity{
+				Country: "PL",
+				Code:    "1234567788",
+			},
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, "1234567788", buyer.NIP)
+		assert.Empty(t, buyer.UECode)
+		assert.Empty(t, buyer.IDNumber)
+	})
+
+	t.Run("sets EU VAT number for customers from other member states", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Beispiel GmbH",
+			TaxID: &tax.Identity{
+				Country: "DE",
+				Code:    "111111125",
+			},
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, "DE", buyer.UECode)
+		assert.Equal(t, "111111125", buyer.UEVatNumber)
+		assert.Empty(t, buyer.NIP)
+		assert.Empty(t, buyer.CountryCode)
+		assert.Empty(t, buyer.IDNumber)
+	})
+
+	t.Run("sets tax ID and country for customers outside the EU", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Sample Inc.",
+			TaxID: &tax.Identity{
+				Country: "US",
+				Code:    "123456789",
+			},
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, "US", buyer.CountryCode)
+		assert.Equal(t, "123456789", buyer.IDNumber)
+		assert.Empty(t, buyer.NIP)
+		assert.Empty(t, buyer.UECode)
+	})
+
+	t.Run("sets no ID marker when the tax ID has no code", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Sample Consumer",
+			TaxID: &tax.Identity{
+				Country: "DE",
+			},
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, 1, buyer.NoID)
+		assert.Empty(t, buyer.UECode)
+	})
+
+	t.Run("sets no ID marker when the customer has no tax ID", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Jan Kowalski",
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, 1, buyer.NoID)
+		assert.Equal(t, "Jan Kowalski", buyer.Name)
+	})
+
+	t.Run("sets no ID marker when there is no customer", func(t *testing.T) {
+		buyer := ksef.NewBuyer(nil)
+
+		assert.Equal(t, 1, buyer.NoID)
+		assert.Empty(t, buyer.Name)
+		assert.Nil(t, buyer.Address)
+	})
+
+	t.Run("sets the EORI, customer number, correspondence address and contacts", func(t *testing.T) {
+		customer := &org.Party{
+			Name: "Sample Consumer",
+			TaxID: &tax.Identity{
+				Country: "PL",
+				Code:    "1234567788",
+			},
+			Identities: []*org.Identity{
+				{Type: ksef.IdentityTypeEORI, Code: "PL123456778800000"},
+				{Type: ksef.IdentityTypeCustomerNumber, Code: "K-1024"},
+			},
+			Addresses: []*org.Address{
+				{Street: "Prosta", Number: "1", Code: "00-001", Locality: "Warszawa", Country: "PL"},
+				{PostOfficeBox: "Skrytka 12", Code: "00-950", Locality: "Warszawa", Country: "PL"},
+			},
+			Emails: []*org.Email{{Address: "billing@example.com"}},
+			Telephones: []*org.Telephone{
+				{Number: "+48 22 123 45 67"},
+				{Number: "+48 600 100 200"},
+			},
+		}
+
+		buyer := ksef.NewBuyer(customer)
+
+		assert.Equal(t, "PL123456778800000", buyer.EORI)
+		assert.Equal(t, "K-1024", buyer.CustomerNumber)
+		assert.Equal(t, &ksef.Address{CountryCode: "PL", AddressL1: "Skrytka 12", AddressL2: "00-950, Warszawa"}, buyer.CorrespondenceAddress)
+		assert.Equal(t, []*ksef.ContactDetails{
+			{Email: "billing@example.com", Phone: "+48 22 123 45 67"},
+			{Phone: "+48 600 100 200"},
+		}, buyer.Contacts)
+	})
+}
+
+func TestNewThirdParties(t *testing.T) {
+	t.Run("returns no third parties by default", func(t *testing.T) {
+		parties, err := ksef.NewThirdParties(&bill.Invoice{})
+
+		require.NoError(t, err)
+		assert.Empty(t, parties)
+	})
+
+	t.Run("sets the payer role of the ordering buyer by default", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{Buyer: thirdParty("Payer Sp. z o.o.")},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Equal(t, "Payer Sp. z o.o.", parties[0].Name)
+		assert.Equal(t, "6", parties[0].Role)
+	})
+
+	t.Run("sets the factor role of the payee from its extension", func(t *testing.T) {
+		payee := thirdParty("Factor Sp. z o.o.")
+		payee.Ext = tax.Extensions{ksef.ExtKeyThirdPartyRole: ksef.ThirdPartyRoleFactor}
+		inv := &bill.Invoice{
+			Payment: &bill.PaymentDetails{Payee: payee},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Equal(t, "1", parties[0].Role)
+	})
+
+	t.Run("ignores a payee with no role", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Payment: &bill.PaymentDetails{Payee: thirdParty("Factor Sp. z o.o.")},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		assert.Empty(t, parties)
+	})
+
+	t.Run("describes the payee by its label", func(t *testing.T) {
+		payee := thirdParty("Collection Agency")
+		payee.Label = "Odbiorca płatności"
+		inv := &bill.Invoice{
+			Payment: &bill.PaymentDetails{Payee: payee},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Equal(t, 1, parties[0].OtherRoleMarker)
+		assert.Equal(t, "Odbiorca płatności", parties[0].RoleDescription)
+	})
+
+	t.Run("sets the role of the delivery receiver from its extension", func(t *testing.T) {
+		receiver := thirdParty("Branch Office")
+		receiver.Ext = tax.Extensions{ksef.ExtKeyThirdPartyRole: ksef.ThirdPartyRoleRecipient}
+		inv := &bill.Invoice{
+			Delivery: &bill.DeliveryDetails{Receiver: receiver},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Equal(t, "2", parties[0].Role)
+	})
+
+	t.Run("describes the delivery receiver by its label", func(t *testing.T) {
+		receiver := thirdParty("Magazyn Centralny Sp. z o.o.")
+		receiver.Label = "Odbiorca towaru"
+		inv := &bill.Invoice{
+			Delivery: &bill.DeliveryDetails{Receiver: receiver},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Empty(t, parties[0].Role)
+		assert.Equal(t, 1, parties[0].OtherRoleMarker)
+		assert.Equal(t, "Odbiorca towaru", parties[0].RoleDescription)
+	})
+
+	t.Run("sets the role and share from party extensions", func(t *testing.T) {
+		buyer := thirdParty("Second Buyer")
+		buyer.Ext = tax.Extensions{
+			ksef.ExtKeyThirdPartyRole:  ksef.ThirdPartyRoleBuyer,
+			ksef.ExtKeyThirdPartyShare: "33.33",
+		}
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{Buyer: buyer},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Equal(t, "4", parties[0].Role)
+		assert.Equal(t, "33.33", parties[0].Share)
+	})
+
+	t.Run("identifies parties by tax ID", func(t *testing.T) {
+		payee := thirdParty("Factor Sp. z o.o.")
+		payee.TaxID = &tax.Identity{Country: "PL", Code: "1234567788"}
+		payee.Ext = tax.Extensions{ksef.ExtKeyThirdPartyRole: ksef.ThirdPartyRoleFactor}
+		inv := &bill.Invoice{
+			Payment: &bill.PaymentDetails{Payee: payee},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		assert.Equal(t, "1234567788", parties[0].NIP)
+		assert.Zero(t, parties[0].NoID)
+	})
+
+	t.Run("fails with an invalid role", func(t *testing.T) {
+		payee := thirdParty("Factor Sp. z o.o.")
+		payee.Ext = tax.Extensions{ksef.ExtKeyThirdPartyRole: "11"}
+
+		_, err := ksef.NewThirdParties(&bill.Invoice{Payment: &bill.PaymentDetails{Payee: payee}})
+
+		assert.ErrorContains(t, err, "invalid third party role '11'")
+	})
+
+	t.Run("fails with a share over 100 percent", func(t *testing.T) {
+		payee := thirdParty("Factor Sp. z o.o.")
+		payee.Ext = tax.Extensions{
+			ksef.ExtKeyThirdPartyRole:  ksef.ThirdPartyRoleFactor,
+			ksef.ExtKeyThirdPartyShare: "120",
+		}
+
+		_, err := ksef.NewThirdParties(&bill.Invoice{Payment: &bill.PaymentDetails{Payee: payee}})
+
+		assert.ErrorContains(t, err, "invalid third party share '120'")
+	})
+
+	t.Run("describes a delivery receiver with no role as the receiver", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Delivery: &bill.DeliveryDetails{Receiver: thirdParty("Branch Office")},
+		}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		require.Len(t, parties, 1)
+		assert.Empty(t, parties[0].Role)
+		assert.Equal(t, 1, parties[0].OtherRoleMarker)
+		assert.Equal(t, "Odbiorca", parties[0].RoleDescription)
+	})
+
+	t.Run("fails when a party has no address", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Delivery: &bill.DeliveryDetails{Receiver: &org.Party{Name: "Branch Office"}},
+		}
+
+		_, err := ksef.NewThirdParties(inv)
+
+		assert.ErrorContains(t, err, "missing address for third party 'Branch Office'")
+	})
+}
+
+func TestNewAuthorisedParty(t *testing.T) {
+	t.Run("returns nil without an ordering seller", func(t *testing.T) {
+		ap, err := ksef.NewAuthorisedParty(&bill.Invoice{Ordering: &bill.Ordering{}})
+
+		require.NoError(t, err)
+		assert.Nil(t, ap)
+	})
+
+	t.Run("sets the authorised entity from the ordering seller", func(t *testing.T) {
+		inv := &bill.Invoice{Ordering: &bill.Ordering{Seller: bailiff()}}
+
+		ap, err := ksef.NewAuthorisedParty(inv)
+
+		require.NoError(t, err)
+		assert.Equal(t, "7980332920", ap.NIP)
+		assert.Equal(t, "Komornik Sądowy Jan Kowalski", ap.Name)
+		assert.Equal(t, "2", ap.Role)
+		assert.Equal(t, "komornik@example.com", ap.Contact.Email)
+		assert.Equal(t, "PL", ap.Address.CountryCode)
+	})
+
+	t.Run("does not report the ordering seller as a third party", func(t *testing.T) {
+		seller := thirdParty("Agent Sp. z o.o.")
+		seller.Label = "Agent"
+		inv := &bill.Invoice{Ordering: &bill.Ordering{Seller: seller}}
+
+		parties, err := ksef.NewThirdParties(inv)
+
+		require.NoError(t, err)
+		assert.Empty(t, parties)
+	})
+
+	t.Run("returns nil when the ordering seller has no role", func(t *testing.T) {
+		seller := thirdParty("Agent Sp. z o.o.")
+		seller.Label = "Agent"
+
+		ap, err := ksef.NewAuthorisedParty(&bill.Invoice{Ordering: &bill.Ordering{Seller: seller}})
+
+		require.NoError(t, err)
+		assert.Nil(t, ap)
+	})
+
+	t.Run("fails with an invalid role", func(t *testing.T) {
+		seller := bailiff()
+		seller.Ext[ksef.ExtKeyAuthorisedRole] = "4"
+
+		_, err := ksef.NewAuthorisedParty(&bill.Invoice{Ordering: &bill.Ordering{Seller: seller}})
+
+		assert.ErrorContains(t, err, "invalid authorised entity role '4'")
+	})
+
+	t.Run("fails without a polish tax ID", func(t *testing.T) {
+		seller := bailiff()
+		seller.TaxID = &tax.Identity{Country: "DE", Code: "111111125"}
+
+		_, err := ksef.NewAuthorisedParty(&bill.Invoice{Ordering: &bill.Ordering{Seller: seller}})
+
+		assert.ErrorContains(t, err, "missing polish tax ID for authorised entity")
+	})
+}
+
+func bailiff() *org.Party {
+	p := thirdParty("Komornik Sądowy Jan Kowalski")
+	p.TaxID = &tax.Identity{Country: "PL", Code: "7980332920"}
+	p.Emails = []*org.Email{{Address: "komornik@example.com"}}
+	p.Ext = tax.Extensions{ksef.ExtKeyAuthorisedRole: ksef.AuthorisedRoleBailiff}
+	return p
+}
+
+func thirdParty(name string) *org.Party {
+	return &org.Party{
+		Name: name,
+		Addresses: []*org.Address{
+			{
+				Street:   "Marszałkowska",
+				Number:   "10",
+				Code:     "00-001",
+				Locality: "Warszawa",
+				Country:  "PL",
+			},
+		},
+	}
+}
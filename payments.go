@@ -2,12 +2,23 @@ package ksef
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
 	"github.com/invopop/gobl/regimes/pl"
 )
 
+// bankAccountTypes lists the valid own bank account type codes of FA(2)
+var bankAccountTypes = []cbc.Code{
+	BankAccountTypeReceivables,
+	BankAccountTypeCollection,
+	BankAccountTypeOwn,
+}
+
 // AdvancePayment defines the XML structure for KSeF advance payments
 type AdvancePayment struct {
 	PaymentAmount string `xml:"KwotaZaplatyCzesciowej,omitempty"`
@@ -24,13 +35,14 @@ type DueDate struct {
 type BankAccount struct {
 	AccountNumber         string `xml:"NrRB"`
 	SWIFT                 string `xml:"SWIFT,omitempty"`
-	BankSelfAccountMarker int    `xml:"RachunekWlasnyBanku,omitempty"` // enum - 1,2,3, not sure what exactly they mean
+	BankSelfAccountMarker int    `xml:"RachunekWlasnyBanku,omitempty"`
 	BankName              string `xml:"NazwaBanku,omitempty"`
 	AccountDescription    string `xml:"OpisRachunku,omitempty"`
 }
 
-// Discount defines the XML structure for KSeF discount
-type Discount struct { // TODO
+// Discount defines the XML structure for KSeF early payment discount
+// (Skonto)
+type Discount struct {
 	Conditions string `xml:"WarunkiSkonta,omitempty"`
 	Amount     string `xml:"WysokoscSkonta,omitempty"`
 }
@@ -46,14 +58,14 @@ type Payment struct {
 	OtherPaymentMeanMarker string            `xml:"PlatnoscInna,omitempty"`
 	OtherPaymentMean       string            `xml:"OpisPlatnosci,omitempty"`
 	BankAccounts           []*BankAccount    `xml:"RachunekBankowy,omitempty"`
-	FactorBankAccounts     []*BankAccount    `xml:"RachunekBankowyFaktora,omitempty"` // not sure if supported by gobl
-	Discount               *Discount         `xml:"Skonto,omitempty"`                 // it's some special discount for early payments
+	FactorBankAccounts     []*BankAccount    `xml:"RachunekBankowyFaktora,omitempty"`
+	Discount               *Discount         `xml:"Skonto,omitempty"`
 }
 
 // NewPayment gets payment data from GOBL invoice
-func NewPayment(pay *bill.PaymentDetails, totals *bill.Totals) *Payment {
+func NewPayment(pay *bill.PaymentDetails, totals *bill.Totals) (*Payment, error) {
 	if pay == nil {
-		return nil
+		return nil, nil
 	}
 
 	var payment = &Payment{
@@ -67,6 +79,9 @@ func NewPayment(pay *bill.PaymentDetails, totals *bill.Totals) *Payment {
 		if err != nil {
 			payment.OtherPaymentMeanMarker = "1"
 			payment.OtherPaymentMean = instructions.Key.String()
+			if instructions.Detail != "" {
+				payment.OtherPaymentMean = instructions.Detail
+			}
 		} else {
 			payment.PaymentMean = PaymentMeansCode
 		}
@@ -74,22 +89,36 @@ func NewPayment(pay *bill.PaymentDetails, totals *bill.Totals) *Payment {
 		payment.BankAccounts = []*BankAccount{}
 		payment.FactorBankAccounts = []*BankAccount{}
 
+		marker, err := bankAccountType(instructions)
+		if err != nil {
+			return nil, err
+		}
 		for _, account := range instructions.CreditTransfer {
-			payment.BankAccounts = append(payment.BankAccounts, &BankAccount{
-				AccountNumber: account.Number,
-				SWIFT:         account.BIC,
-				BankName:      account.Name,
-			})
+			ba := newBankAccount(account)
+			ba.BankSelfAccountMarker = marker
+			if isFactor(pay.Payee) {
+				// payments to a factor go to its accounts
+				payment.FactorBankAccounts = append(payment.FactorBankAccounts, ba)
+				continue
+			}
+			payment.BankAccounts = append(payment.BankAccounts, ba)
 		}
 	}
 
 	if terms := pay.Terms; terms != nil {
 		for _, dueDate := range pay.Terms.DueDates {
+			// the notes describe terms relative to an event, such as
+			// "14 days from delivery"
 			payment.DueDates = append(payment.DueDates, &DueDate{
 				Date:        dueDate.Date.String(),
-				Description: dueDate.Amount.String(),
+				Description: dueDate.Notes,
 			})
 		}
+		discount, err := newDiscount(terms)
+		if err != nil {
+			return nil, err
+		}
+		payment.Discount = discount
 	}
 
 	if advances := pay.Advances; advances != nil {
@@ -107,22 +136,118 @@ func NewPayment(pay *bill.PaymentDetails, totals *bill.Totals) *Payment {
 		}
 	}
 
-	return payment
+	return payment, nil
 }
 
-func findPaymentMeansCode(key cbc.Key) (string, error) {
-	keyDef := findPaymentKeyDefinition(key)
+// newBankAccount gets the bank account data from a GOBL credit transfer,
+// preferring the IBAN to the local account number. GOBL credit transfers
+// have no description of their own, so the account description is not set.
+func newBankAccount(account *pay.CreditTransfer) *BankAccount {
+	number := account.IBAN
+	if number == "" {
+		number = account.Number
+	}
 
-	if keyDef == nil {
-		return "", fmt.Errorf("FormaPlatnosci Code not found for payment method key '%s'", key)
+	return &BankAccount{
+		AccountNumber: normalizeAccountNumber(number),
+		SWIFT:         account.BIC,
+		BankName:      account.Name,
 	}
+}
 
-	code := keyDef.Map[pl.KeyFAVATPaymentType]
+// bankAccountType gets the own bank account type set on the instructions.
+// GOBL credit transfers have no extensions, so the type is only accepted
+// for instructions with a single account it can refer to.
+func bankAccountType(instructions *pay.Instructions) (int, error) {
+	code := instructions.Ext.Get(ExtKeyBankAccountType)
 	if code == "" {
-		return "", fmt.Errorf("FormaPlatnosci Code not found for payment method key '%s'", key)
+		return 0, nil
+	}
+	if len(instructions.CreditTransfer) != 1 {
+		return 0, fmt.Errorf("bank account type '%s' set for %d credit transfer accounts", code, len(instructions.CreditTransfer))
+	}
+	if !code.In(bankAccountTypes...) {
+		return 0, fmt.Errorf("invalid bank account type '%s'", code)
+	}
+	marker, _ := strconv.Atoi(code.String())
+	return marker, nil
+}
+
+// normalizeAccountNumber removes the separators of an account number, which
+// KSeF only accepts as digits and capital letters
+func normalizeAccountNumber(number string) string {
+	number = strings.ToUpper(number)
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, number)
+}
+
+// setAdvancePaid reports the payment of an advance invoice, whose amount is
+// the advance received, as paid on the date of the last advance
+func (p *Payment) setAdvancePaid(advances []*pay.Advance) {
+	p.PartiallyPaidMarker = ""
+	p.AdvancePayments = []*AdvancePayment{}
+	p.PaidMarker = "1"
+	p.PaymentDate = ""
+	if n := len(advances); n > 0 && advances[n-1].Date != nil {
+		p.PaymentDate = advances[n-1].Date.String()
+	}
+}
+
+// isFactor reports whether the payee of the invoice is a factor, which must
+// be set explicitly with its role
+func isFactor(payee *org.Party) bool {
+	return payee != nil && payee.Ext.Get(ExtKeyThirdPartyRole) == ThirdPartyRoleFactor
+}
+
+// newDiscount gets the early payment discount from the payment terms, with
+// the percentage given by the extension and the conditions described in the
+// notes or details of the terms, which are required
+func newDiscount(terms *pay.Terms) (*Discount, error) {
+	percent := terms.Ext.Get(ExtKeyEarlyPaymentDiscount)
+	if percent == "" {
+		return nil, nil
+	}
+
+	conditions := terms.Notes
+	if conditions == "" {
+		conditions = terms.Detail
+	}
+	if conditions == "" {
+		return nil, fmt.Errorf("missing conditions of early payment discount '%s'", percent)
+	}
+
+	return &Discount{
+		Conditions: conditions,
+		Amount:     percent.String() + "%",
+	}, nil
+}
+
+// paymentMeansCodes maps the GOBL payment means keys the PL regime does not
+// define to FormaPlatnosci codes
+var paymentMeansCodes = map[cbc.Key]string{
+	pay.MeansKeyBankDraft: "4", // cheque
+}
+
+// findPaymentMeansCode finds the FormaPlatnosci code of a payment means key,
+// falling back to the code of its more generic keys, so that for example
+// "card+debit" is reported as a card payment
+func findPaymentMeansCode(key cbc.Key) (string, error) {
+	for k := key; k != ""; k = k.Pop() {
+		if keyDef := findPaymentKeyDefinition(k); keyDef != nil {
+			if code := keyDef.Map[pl.KeyFAVATPaymentType]; code != "" {
+				return code.String(), nil
+			}
+		}
+		if code, ok := paymentMeansCodes[k]; ok {
+			return code, nil
+		}
 	}
 
-	return code.String(), nil
+	return "", fmt.Errorf("FormaPlatnosci Code not found for payment method key '%s'", key)
 }
 
 func findPaymentKeyDefinition(key cbc.Key) *cbc.Definition {
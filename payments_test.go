@@ -7,15 +7,19 @@ import (
 	ksef "github.com/invopop/gobl.ksef"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewPayment(t *testing.T) {
 	t.Run("should return nil when no payment data passed", func(t *testing.T) {
-		pay := ksef.NewPayment(nil, nil)
+		pay, err := ksef.NewPayment(nil, nil)
+		require.NoError(t, err)
 		assert.Nil(t, pay)
 	})
 
@@ -25,7 +29,8 @@ func TestNewPayment(t *testing.T) {
 		}
 		totals := &bill.Totals{}
 
-		pay := ksef.NewPayment(payment, totals)
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
 		result := &ksef.Payment{
 			PaidMarker:             "",
 			PaymentDate:            "",
@@ -50,7 +55,8 @@ func TestNewPayment(t *testing.T) {
 			},
 		}
 		totals := &bill.Totals{}
-		pay := ksef.NewPayment(payment, totals)
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
 		result := &ksef.Payment{
 			PaidMarker:             "",
 			PaymentDate:            "",
@@ -68,6 +74,175 @@ func TestNewPayment(t *testing.T) {
 		assert.Equal(t, result, pay)
 	})
 
+	t.Run("should map payment means keys to payment method codes", func(t *testing.T) {
+		tests := map[cbc.Key]string{
+			"cash":                 "1",
+			"card":                 "2",
+			"other+coupon":         "3",
+			"cheque":               "4",
+			"bank-draft":           "4",
+			"online+loan":          "5",
+			"credit-transfer":      "6",
+			"credit-transfer+sepa": "6",
+			"other+mobile":         "7",
+		}
+		for key, code := range tests {
+			payment := &bill.PaymentDetails{
+				Instructions: &pay.Instructions{Key: key},
+			}
+			pay, err := ksef.NewPayment(payment, &bill.Totals{})
+			require.NoError(t, err)
+			assert.Equal(t, code, pay.PaymentMean, key)
+			assert.Empty(t, pay.OtherPaymentMeanMarker, key)
+		}
+	})
+
+	t.Run("should report payment means keys not defined by GOBL as other", func(t *testing.T) {
+		for _, key := range []cbc.Key{"other+voucher", "online+card", "other+loan", "online+mobile"} {
+			payment := &bill.PaymentDetails{
+				Instructions: &pay.Instructions{Key: key},
+			}
+			pay, err := ksef.NewPayment(payment, &bill.Totals{})
+			require.NoError(t, err)
+
+			assert.Empty(t, pay.PaymentMean, key)
+			assert.Equal(t, "1", pay.OtherPaymentMeanMarker, key)
+			assert.Equal(t, key.String(), pay.OtherPaymentMean, key)
+		}
+	})
+
+	t.Run("should describe other payment means with the instructions detail", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Instructions: &pay.Instructions{
+				Key:    "netting",
+				Detail: "Offset against invoice FV/1/2023",
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		assert.Empty(t, pay.PaymentMean)
+		assert.Equal(t, "1", pay.OtherPaymentMeanMarker)
+		assert.Equal(t, "Offset against invoice FV/1/2023", pay.OtherPaymentMean)
+	})
+
+	t.Run("should set bank accounts with normalized IBAN", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Instructions: &pay.Instructions{
+				Key:    "credit-transfer",
+				Detail: "Main account",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "pl61 1090 1014 0000 0712 1981 2874", Number: "61109010140000071219812874", BIC: "WBKPPLPP", Name: "Santander"},
+					{Number: "10-1090-1014-0000-0712-1981-2874"},
+				},
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []*ksef.BankAccount{
+			{
+				AccountNumber: "PL61109010140000071219812874",
+				SWIFT:         "WBKPPLPP",
+				BankName:      "Santander",
+			},
+			{
+				AccountNumber: "10109010140000071219812874",
+			},
+		}, pay.BankAccounts)
+		assert.Empty(t, pay.FactorBankAccounts)
+	})
+
+	t.Run("should set the own bank account type of a single account", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Instructions: &pay.Instructions{
+				Key: "credit-transfer",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "PL61109010140000071219812874"},
+				},
+				Ext: tax.Extensions{ksef.ExtKeyBankAccountType: ksef.BankAccountTypeOwn},
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		require.Len(t, pay.BankAccounts, 1)
+		assert.Equal(t, 3, pay.BankAccounts[0].BankSelfAccountMarker)
+	})
+
+	t.Run("should fail when the own bank account type applies to several accounts", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Instructions: &pay.Instructions{
+				Key: "credit-transfer",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "PL61109010140000071219812874"},
+					{IBAN: "PL10105000997603123456789123"},
+				},
+				Ext: tax.Extensions{ksef.ExtKeyBankAccountType: ksef.BankAccountTypeOwn},
+			},
+		}
+		_, err := ksef.NewPayment(payment, &bill.Totals{})
+
+		assert.ErrorContains(t, err, "bank account type '3' set for 2 credit transfer accounts")
+	})
+
+	t.Run("should set factor bank accounts when the payee is a factor", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Payee: &org.Party{
+				Name: "Factor Sp. z o.o.",
+				Ext:  tax.Extensions{ksef.ExtKeyThirdPartyRole: ksef.ThirdPartyRoleFactor},
+			},
+			Instructions: &pay.Instructions{
+				Key: "credit-transfer",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "PL61109010140000071219812874"},
+				},
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		assert.Empty(t, pay.BankAccounts)
+		assert.Equal(t, []*ksef.BankAccount{{AccountNumber: "PL61109010140000071219812874"}}, pay.FactorBankAccounts)
+	})
+
+	t.Run("should not set factor bank accounts when the payee has no role", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Payee: &org.Party{Name: "Collections Sp. z o.o."},
+			Instructions: &pay.Instructions{
+				Key: "credit-transfer",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "PL61109010140000071219812874"},
+				},
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		assert.Len(t, pay.BankAccounts, 1)
+		assert.Empty(t, pay.FactorBankAccounts)
+	})
+
+	t.Run("should not set factor bank accounts when the payee has another role", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Payee: &org.Party{
+				Name: "Recipient Sp. z o.o.",
+				Ext:  tax.Extensions{ksef.ExtKeyThirdPartyRole: ksef.ThirdPartyRoleRecipient},
+			},
+			Instructions: &pay.Instructions{
+				Key: "credit-transfer",
+				CreditTransfer: []*pay.CreditTransfer{
+					{IBAN: "PL61109010140000071219812874"},
+				},
+			},
+		}
+		pay, err := ksef.NewPayment(payment, &bill.Totals{})
+		require.NoError(t, err)
+
+		assert.Len(t, pay.BankAccounts, 1)
+		assert.Empty(t, pay.FactorBankAccounts)
+	})
+
 	t.Run("should set payment terms", func(t *testing.T) {
 		x := time.Date(2023, time.July, 28, 0, 0, 0, 0, time.UTC)
 		d := cal.DateOf(x)
@@ -76,17 +251,18 @@ func TestNewPayment(t *testing.T) {
 
 		payment := &bill.PaymentDetails{
 			Terms: &pay.Terms{
-				DueDates: []*pay.DueDate{{Date: &d, Amount: num}},
+				DueDates: []*pay.DueDate{{Date: &d, Amount: num, Notes: "14 days from delivery"}},
 			},
 		}
 		totals := &bill.Totals{}
-		pay := ksef.NewPayment(payment, totals)
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
 		result := &ksef.Payment{
 			PaidMarker:             "",
 			PaymentDate:            "",
 			PartiallyPaidMarker:    "",
 			AdvancePayments:        []*ksef.AdvancePayment{},
-			DueDates:               []*ksef.DueDate{{Date: d.String(), Description: num.String()}},
+			DueDates:               []*ksef.DueDate{{Date: d.String(), Description: "14 days from delivery"}},
 			PaymentMean:            "",
 			OtherPaymentMeanMarker: "",
 			OtherPaymentMean:       "",
@@ -98,6 +274,35 @@ func TestNewPayment(t *testing.T) {
 		assert.Equal(t, result, pay)
 	})
 
+	t.Run("should set early payment discount from payment terms", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Terms: &pay.Terms{
+				Notes: "Payment within 7 days of the issue date",
+				Ext:   tax.Extensions{ksef.ExtKeyEarlyPaymentDiscount: "2"},
+			},
+		}
+		totals := &bill.Totals{}
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.Discount{
+			Conditions: "Payment within 7 days of the issue date",
+			Amount:     "2%",
+		}, pay.Discount)
+	})
+
+	t.Run("should fail with an early payment discount without conditions", func(t *testing.T) {
+		payment := &bill.PaymentDetails{
+			Terms: &pay.Terms{
+				Ext: tax.Extensions{ksef.ExtKeyEarlyPaymentDiscount: "2"},
+			},
+		}
+		totals := &bill.Totals{}
+		_, err := ksef.NewPayment(payment, totals)
+
+		assert.ErrorContains(t, err, "missing conditions of early payment discount '2'")
+	})
+
 	t.Run("advances should set paid marker and date", func(t *testing.T) {
 		x := time.Date(2023, time.July, 28, 0, 0, 0, 0, time.UTC)
 		d := cal.DateOf(x)
@@ -113,7 +318,8 @@ func TestNewPayment(t *testing.T) {
 			Due:      &zero,
 			Advances: &firstNum,
 		}
-		pay := ksef.NewPayment(payment, totals)
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
 		result := &ksef.Payment{
 			PaidMarker:             "1",
 			PaymentDate:            d.String(),
@@ -146,7 +352,8 @@ func TestNewPayment(t *testing.T) {
 			Due:      &secondNum,
 			Advances: &firstNum,
 		}
-		pay := ksef.NewPayment(payment, totals)
+		pay, err := ksef.NewPayment(payment, totals)
+		require.NoError(t, err)
 		result := &ksef.Payment{
 			PaidMarker:             "",
 			PaymentDate:            "",
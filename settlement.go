@@ -0,0 +1,95 @@
+package ksef
+
+import (
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/tax"
+)
+
+// Settlement defines the XML structure for KSeF additional settlements of
+// amounts outside the taxable base (Rozliczenie)
+type Settlement struct {
+	Charges         []*SettlementItem `xml:"Obciazenia,omitempty"`
+	TotalCharges    string            `xml:"SumaObciazen,omitempty"`
+	Deductions      []*SettlementItem `xml:"Odliczenia,omitempty"`
+	TotalDeductions string            `xml:"SumaOdliczen,omitempty"`
+	AmountDue       string            `xml:"DoZaplaty,omitempty"`
+	// or
+	AmountToSettle string `xml:"DoRozliczenia,omitempty"`
+}
+
+// SettlementItem defines the XML structure for KSeF settlement charge or
+// deduction
+type SettlementItem struct {
+	Amount string `xml:"Kwota"`
+	Reason string `xml:"Powod"`
+}
+
+// newSettlement reports the invoice charges and discounts without VAT, such
+// as deposits, returned packaging or previous balances, as settlements
+// added to or deducted from the total amount receivable. It returns the
+// total amount receivable without them, along with the settlement, which
+// is nil when there are no such charges or discounts.
+func newSettlement(inv *bill.Invoice, payable num.Amount) (*Settlement, num.Amount) {
+	cu := inv.Currency.Def().Subunits
+	s := new(Settlement)
+	total := payable
+
+	charges := num.MakeAmount(0, cu)
+	for _, c := range inv.Charges {
+		if !isSettlement(c.Taxes) {
+			continue
+		}
+		s.Charges = append(s.Charges, &SettlementItem{
+			Amount: c.Amount.Rescale(cu).String(),
+			Reason: settlementReason(c.Reason, chargeLineName),
+		})
+		charges = charges.Add(c.Amount)
+		total = total.Subtract(c.Amount)
+	}
+
+	deductions := num.MakeAmount(0, cu)
+	for _, d := range inv.Discounts {
+		if !isSettlement(d.Taxes) {
+			continue
+		}
+		s.Deductions = append(s.Deductions, &SettlementItem{
+			Amount: d.Amount.Rescale(cu).String(),
+			Reason: settlementReason(d.Reason, discountLineName),
+		})
+		deductions = deductions.Add(d.Amount)
+		total = total.Add(d.Amount)
+	}
+
+	if len(s.Charges) == 0 && len(s.Deductions) == 0 {
+		return nil, payable
+	}
+
+	if len(s.Charges) > 0 {
+		s.TotalCharges = charges.Rescale(cu).String()
+	}
+	if len(s.Deductions) > 0 {
+		s.TotalDeductions = deductions.Rescale(cu).String()
+	}
+	if payable.IsNegative() {
+		// overpaid amounts are settled or refunded instead
+		s.AmountToSettle = payable.Negate().Rescale(cu).String()
+	} else {
+		s.AmountDue = payable.Rescale(cu).String()
+	}
+
+	return s, total
+}
+
+// isSettlement checks if an invoice charge or discount has no VAT, so it is
+// outside the taxable base
+func isSettlement(taxes tax.Set) bool {
+	return taxes.Get(tax.CategoryVAT) == nil
+}
+
+func settlementReason(reason, name string) string {
+	if reason != "" {
+		return reason
+	}
+	return name
+}
@@ -0,0 +1,76 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/tax"
+)
+
+// invoiceTypeSimplified is the invoice type code of simplified invoices
+const invoiceTypeSimplified = "UPR"
+
+// Maximum total amounts of simplified invoices, art. 106e ust. 5 pkt 3
+var (
+	simplifiedLimitPLN = num.MakeAmount(450, 0)
+	simplifiedLimitEUR = num.MakeAmount(100, 0)
+)
+
+// validateSimplified checks that a simplified invoice is within the amount
+// limits and identifies the customer with a tax ID.
+func validateSimplified(inv *bill.Invoice, er *currency.ExchangeRate) error {
+	if inv.Customer == nil || inv.Customer.TaxID == nil || inv.Customer.TaxID.Code == "" {
+		return fmt.Errorf("missing customer tax ID for simplified invoice")
+	}
+
+	total := inv.Totals.TotalWithTax
+	if inv.Currency == currency.EUR {
+		if total.Compare(simplifiedLimitEUR) > 0 {
+			return fmt.Errorf("total of simplified invoice exceeds %s %s", simplifiedLimitEUR, currency.EUR)
+		}
+		return nil
+	}
+	if er != nil {
+		total = er.Convert(total)
+	}
+	if total.Compare(simplifiedLimitPLN) > 0 {
+		return fmt.Errorf("total of simplified invoice exceeds %s %s", simplifiedLimitPLN, currency.PLN)
+	}
+
+	return nil
+}
+
+// newSimplifiedBuyer keeps only the tax ID of the buyer, as simplified
+// invoices may omit its name and address
+func newSimplifiedBuyer(b *Buyer) *Buyer {
+	return &Buyer{
+		NIP:         b.NIP,
+		UECode:      b.UECode,
+		UEVatNumber: b.UEVatNumber,
+		CountryCode: b.CountryCode,
+		IDNumber:    b.IDNumber,
+		NoID:        b.NoID,
+	}
+}
+
+// simplifyLines replaces the quantities and net amounts of the lines with
+// their gross value, which simplified invoices may show instead
+func simplifyLines(lines []*Line, src []*bill.Line, inv *bill.Invoice) {
+	cu := inv.Currency.Def().Subunits
+	for i, l := range lines {
+		line := src[i]
+		gross := line.Total.Rescale(cu)
+		if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil && tc.Percent != nil && !pricesIncludeVAT(inv) {
+			gross = gross.Add(tc.Percent.Of(gross).Rescale(cu))
+		}
+		l.Measure = ""
+		l.Quantity = ""
+		l.NetUnitPrice = ""
+		l.GrossUnitPrice = ""
+		l.UnitDiscount = ""
+		l.NetPriceTotal = ""
+		l.GrossPriceTotal = gross.String()
+	}
+}
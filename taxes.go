@@ -0,0 +1,157 @@
+package ksef
+
+import (
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/regimes/pl"
+	"github.com/invopop/gobl/tax"
+)
+
+// Extension codes of the PL regime used to classify VAT rates
+const (
+	vatSpecialTaxi cbc.Code = "taxi"
+	vatZeroWDT     cbc.Code = "wdt"
+	vatZeroExport  cbc.Code = "export"
+)
+
+// vatGroup identifies the pair of P_13_x and P_14_x summary fields a VAT
+// rate is reported in
+type vatGroup int
+
+const (
+	vatGroupNone               vatGroup = iota
+	vatGroupStandard                    // P_13_1
+	vatGroupReduced                     // P_13_2
+	vatGroupSuperReduced                // P_13_3
+	vatGroupTaxi                        // P_13_4
+	vatGroupSpecialProcedure            // P_13_5
+	vatGroupZeroDomestic                // P_13_6_1
+	vatGroupZeroIntraCommunity          // P_13_6_2
+	vatGroupZeroExport                  // P_13_6_3
+	vatGroupExempt                      // P_13_7
+	vatGroupNotPursuant                 // P_13_8
+	vatGroupNotPursuantArt100           // P_13_9
+	vatGroupReverseCharge               // P_13_10
+	vatGroupMargin                      // P_13_11
+)
+
+// Textual VAT rate codes of lines taxed at rates without a percentage. FA(2)
+// does not distinguish the kinds of zero rated and not pursuant sales.
+const (
+	vatRateExempt        = "zw"
+	vatRateReverseCharge = "oo"
+	vatRateNotPursuant   = "np"
+)
+
+// vatRateCodes are the values of the P_12 VAT rate of lines, as defined in
+// FA(2)
+var vatRateCodes = []string{"23", "22", "8", "7", "5", "4", "3", "0", "zw", "oo", "np"}
+
+// vatContext holds the invoice data needed to classify VAT rates
+type vatContext struct {
+	// reverseCharge reports exempt rates as reverse charge sales
+	reverseCharge bool
+	// intraCommunity reports zero rates without a zero rate extension as
+	// intra-community supplies (WDT)
+	intraCommunity bool
+}
+
+func newVATContext(inv *bill.Invoice) *vatContext {
+	return &vatContext{
+		reverseCharge:  inv.HasTags(tax.TagReverseCharge),
+		intraCommunity: isIntraCommunityCustomer(inv.Customer),
+	}
+}
+
+// vatTotal accumulates the base and tax amounts of a VAT group
+type vatTotal struct {
+	Base   num.Amount
+	Amount num.Amount
+}
+
+// newVATGroup determines the summary group of a VAT rate from its key,
+// country and PL extensions, using the invoice context for the cases the
+// rate alone does not determine.
+func newVATGroup(key cbc.Key, country l10n.TaxCountryCode, ext tax.Extensions, vc *vatContext) vatGroup {
+	if ext.Has(ExtKeyMarginScheme) {
+		// sales under a margin scheme include the VAT on the margin
+		return vatGroupMargin
+	}
+	if isOSSCountry(country) {
+		// VAT of another member state, charged under the OSS procedure
+		return vatGroupSpecialProcedure
+	}
+
+	switch key {
+	case tax.RateStandard:
+		return vatGroupStandard
+	case tax.RateReduced:
+		return vatGroupReduced
+	case tax.RateSuperReduced:
+		return vatGroupSuperReduced
+	case tax.RateSpecial:
+		if ext.Get(pl.ExtKeyKSeFVATSpecial) == vatSpecialTaxi {
+			return vatGroupTaxi
+		}
+	case tax.RateZero:
+		switch ext.Get(pl.ExtKeyKSeFVATZero) {
+		case vatZeroWDT:
+			return vatGroupZeroIntraCommunity
+		case vatZeroExport:
+			return vatGroupZeroExport
+		case "":
+			if vc.intraCommunity {
+				return vatGroupZeroIntraCommunity
+			}
+		}
+		return vatGroupZeroDomestic
+	case tax.RateExempt:
+		if vc.reverseCharge {
+			return vatGroupReverseCharge
+		}
+		return vatGroupExempt
+	case pl.TaxRateNotPursuant:
+		return vatGroupNotPursuant
+	case pl.TaxRateNotPursuantArt100:
+		return vatGroupNotPursuantArt100
+	}
+
+	return vatGroupNone
+}
+
+// newVATTotals groups the VAT rate totals of an invoice by summary field
+func newVATTotals(taxes *tax.Total, vc *vatContext) map[vatGroup]*vatTotal {
+	totals := make(map[vatGroup]*vatTotal)
+	if taxes == nil {
+		return totals
+	}
+
+	for _, cat := range taxes.Categories {
+		if cat.Code != tax.CategoryVAT {
+			continue
+		}
+
+		for _, rate := range cat.Rates {
+			g := newVATGroup(rate.Key, rate.Country, rate.Ext, vc)
+			if g == vatGroupNone {
+				continue
+			}
+			if t, ok := totals[g]; ok {
+				t.Base = t.Base.Add(rate.Base)
+				t.Amount = t.Amount.Add(rate.Amount)
+				continue
+			}
+			totals[g] = &vatTotal{Base: rate.Base, Amount: rate.Amount}
+		}
+	}
+
+	return totals
+}
+
+// isOSSCountry reports whether VAT charged in a country is the VAT of
+// another member state, charged under the OSS procedure
+func isOSSCountry(country l10n.TaxCountryCode) bool {
+	return country != "" && country != l10n.PL.Tax()
+}
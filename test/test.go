@@ -33,7 +33,8 @@ func LoadTestInvoice(name string) (*bill.Invoice, error) {
 	return env.Extract().(*bill.Invoice), nil
 }
 
-// LoadTestEnvelope returns a GOBL Envelope from a file in the `test/data` folder
+// LoadTestEnvelope returns a validated GOBL Envelope from a file in the
+// `test/data` folder
 func LoadTestEnvelope(name string) (*gobl.Envelope, error) {
 	src, _ := os.Open(filepath.Join(GetDataPath(), name))
 
@@ -46,6 +47,9 @@ func LoadTestEnvelope(name string) (*gobl.Envelope, error) {
 	if err := json.Unmarshal(buf.Bytes(), env); err != nil {
 		return nil, err
 	}
+	if err := env.Validate(); err != nil {
+		return nil, err
+	}
 
 	return env, nil
 }
@@ -0,0 +1,161 @@
+package ksef
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+)
+
+// Meta keys of line items describing a new means of transport supplied
+// within the EU, as defined in art. 2 pkt 10 of the VAT act. Lines are
+// reported as new means of transport when their item has an approval date.
+const (
+	// MetaKeyTransportApprovalDate is the date the vehicle was first
+	// admitted for use, in YYYY-MM-DD format (P_22A)
+	MetaKeyTransportApprovalDate cbc.Key = "pl-ksef-transport-approval-date"
+	// Optional identification of the vehicle
+	MetaKeyTransportMake         cbc.Key = "pl-ksef-transport-make"         // P_22BMK
+	MetaKeyTransportModel        cbc.Key = "pl-ksef-transport-model"        // P_22BMD
+	MetaKeyTransportColour       cbc.Key = "pl-ksef-transport-colour"       // P_22BK
+	MetaKeyTransportRegistration cbc.Key = "pl-ksef-transport-registration" // P_22BNR
+	MetaKeyTransportYear         cbc.Key = "pl-ksef-transport-year"         // P_22BRP
+	// Land vehicles require the mileage, and may have one identification
+	// number and a vehicle type
+	MetaKeyTransportMileage       cbc.Key = "pl-ksef-transport-mileage"        // P_22B
+	MetaKeyTransportVIN           cbc.Key = "pl-ksef-transport-vin"            // P_22B1
+	MetaKeyTransportBodyNumber    cbc.Key = "pl-ksef-transport-body-number"    // P_22B2
+	MetaKeyTransportChassisNumber cbc.Key = "pl-ksef-transport-chassis-number" // P_22B3
+	MetaKeyTransportFrameNumber   cbc.Key = "pl-ksef-transport-frame-number"   // P_22B4
+	MetaKeyTransportVehicleType   cbc.Key = "pl-ksef-transport-vehicle-type"   // P_22BT
+	// Vessels require the hours of use, and may have a hull number
+	MetaKeyTransportSailingHours cbc.Key = "pl-ksef-transport-sailing-hours" // P_22C
+	MetaKeyTransportHullNumber   cbc.Key = "pl-ksef-transport-hull-number"   // P_22C1
+	// Aircraft require the hours of use, and may have a serial number
+	MetaKeyTransportFlightHours  cbc.Key = "pl-ksef-transport-flight-hours"  // P_22D
+	MetaKeyTransportSerialNumber cbc.Key = "pl-ksef-transport-serial-number" // P_22D1
+)
+
+// NewTransport defines the XML structure for KSeF intra-community supply of
+// new means of transport annotation
+type NewTransport struct {
+	NewTransportMarker int                    `xml:"P_22,omitempty"`
+	Art42Obligation    int                    `xml:"P_42_5,omitempty"`
+	Vehicles           []*NewMeansOfTransport `xml:"NowySrodekTransportu,omitempty"`
+	NoNewTransport     int                    `xml:"P_22N,omitempty"`
+}
+
+// NewMeansOfTransport defines the XML structure for KSeF new means of
+// transport data
+type NewMeansOfTransport struct {
+	ApprovalDate       string `xml:"P_22A"`
+	LineNumber         int    `xml:"P_NrWierszaNST"`
+	Make               string `xml:"P_22BMK,omitempty"`
+	Model              string `xml:"P_22BMD,omitempty"`
+	Colour             string `xml:"P_22BK,omitempty"`
+	RegistrationNumber string `xml:"P_22BNR,omitempty"`
+	ProductionYear     string `xml:"P_22BRP,omitempty"`
+	// land vehicles
+	Mileage       string `xml:"P_22B,omitempty"`
+	VIN           string `xml:"P_22B1,omitempty"`
+	BodyNumber    string `xml:"P_22B2,omitempty"`
+	ChassisNumber string `xml:"P_22B3,omitempty"`
+	FrameNumber   string `xml:"P_22B4,omitempty"`
+	VehicleType   string `xml:"P_22BT,omitempty"`
+	// or vessels
+	SailingHours string `xml:"P_22C,omitempty"`
+	HullNumber   string `xml:"P_22C1,omitempty"`
+	// or aircraft
+	FlightHours  string `xml:"P_22D,omitempty"`
+	SerialNumber string `xml:"P_22D1,omitempty"`
+}
+
+// newTransport sets the new means of transport annotation from the lines
+// whose items describe a new means of transport, which are only reported
+// for intra-community supplies
+func newTransport(inv *bill.Invoice) (*NewTransport, error) {
+	var vehicles []*NewMeansOfTransport
+	for _, line := range inv.Lines {
+		if line.Item == nil || line.Item.Meta[MetaKeyTransportApprovalDate] == "" {
+			continue
+		}
+		if !isIntraCommunityCustomer(inv.Customer) {
+			return nil, fmt.Errorf("new means of transport in line %d not supplied to a customer of another EU member state", line.Index)
+		}
+		v, err := newMeansOfTransport(line)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, v)
+	}
+
+	if len(vehicles) == 0 {
+		return &NewTransport{NoNewTransport: 1}, nil
+	}
+
+	nt := &NewTransport{
+		NewTransportMarker: 1,
+		Art42Obligation:    2,
+		Vehicles:           vehicles,
+	}
+	if inv.HasTags(TagArt42Obligation) {
+		nt.Art42Obligation = 1
+	}
+
+	return nt, nil
+}
+
+// newMeansOfTransport gets the new means of transport data from the meta
+// of a line item, checking that it describes exactly one kind of vehicle
+func newMeansOfTransport(line *bill.Line) (*NewMeansOfTransport, error) {
+	meta := line.Item.Meta
+	v := &NewMeansOfTransport{
+		ApprovalDate:       meta[MetaKeyTransportApprovalDate],
+		LineNumber:         line.Index,
+		Make:               meta[MetaKeyTransportMake],
+		Model:              meta[MetaKeyTransportModel],
+		Colour:             meta[MetaKeyTransportColour],
+		RegistrationNumber: meta[MetaKeyTransportRegistration],
+		ProductionYear:     meta[MetaKeyTransportYear],
+		Mileage:            meta[MetaKeyTransportMileage],
+		VIN:                meta[MetaKeyTransportVIN],
+		BodyNumber:         meta[MetaKeyTransportBodyNumber],
+		ChassisNumber:      meta[MetaKeyTransportChassisNumber],
+		FrameNumber:        meta[MetaKeyTransportFrameNumber],
+		VehicleType:        meta[MetaKeyTransportVehicleType],
+		SailingHours:       meta[MetaKeyTransportSailingHours],
+		HullNumber:         meta[MetaKeyTransportHullNumber],
+		FlightHours:        meta[MetaKeyTransportFlightHours],
+		SerialNumber:       meta[MetaKeyTransportSerialNumber],
+	}
+
+	if _, err := time.Parse("2006-01-02", v.ApprovalDate); err != nil {
+		return nil, fmt.Errorf("invalid approval date '%s' of new means of transport in line %d", v.ApprovalDate, line.Index)
+	}
+
+	land := nonEmpty(v.VIN, v.BodyNumber, v.ChassisNumber, v.FrameNumber, v.VehicleType) > 0
+	switch {
+	case nonEmpty(v.Mileage, v.SailingHours, v.FlightHours) != 1:
+		return nil, fmt.Errorf("new means of transport in line %d must have either mileage, sailing hours or flight hours", line.Index)
+	case nonEmpty(v.VIN, v.BodyNumber, v.ChassisNumber, v.FrameNumber) > 1:
+		return nil, fmt.Errorf("new means of transport in line %d can only have one of VIN, body, chassis or frame number", line.Index)
+	case land && v.Mileage == "",
+		v.HullNumber != "" && v.SailingHours == "",
+		v.SerialNumber != "" && v.FlightHours == "":
+		return nil, fmt.Errorf("new means of transport in line %d mixes data of different kinds of vehicles", line.Index)
+	}
+
+	return v, nil
+}
+
+// nonEmpty counts the values that are not empty
+func nonEmpty(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}